@@ -0,0 +1,468 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	// maxBankerIssues 玩家坐庄期数的最大值
+	maxBankerIssues = 1000
+)
+
+var (
+	// errBankerExposure 下注超出庄家可承受的赔付
+	errBankerExposure = errors.New("超出庄家可承受的赔付")
+	// errBankerBet 庄家在自己坐庄的期号下注
+	errBankerBet = errors.New("庄家不能在坐庄期号下注")
+)
+
+// handleBankCommand 处理 "bank" 命令，锁定庄金排队坐庄，不带参数时查询庄家，示例: /bank 10000
+func handleBankCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+	msgConfig.ParseMode = tgbotapi.ModeHTML
+
+	args = strings.TrimSpace(args)
+	if args == "" {
+		msgConfig.Text = formatBankers(chatID)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	amount, err := strconv.Atoi(args)
+	if err != nil || amount <= 0 || amount > math.MaxInt32/2 {
+		msgConfig.Text = "格式错误！示例: /bank 10000"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	chatDiceConfig, err := model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可坐庄！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	userID := chatMember.User.ID
+	if banker, err := getUserBanker(chatID, userID); err != nil {
+		log.Println("查询庄家异常:", err)
+		return
+	} else if banker != nil {
+		msgConfig.Text = "您已在坐庄或排队坐庄中，可使用 /unbank 下庄"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	currentTime := time.Now().Format("2006-01-02 15:04:05")
+	banker := &model.Banker{
+		ChatID:          chatID,
+		TgUserID:        userID,
+		Bankroll:        amount,
+		InitialBankroll: amount,
+		MaxIssues:       chatDiceConfig.BankerIssues,
+		Status:          model.BankerStatusWaiting,
+		UpdateTime:      currentTime,
+		CreateTime:      currentTime,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(banker); result.Error != nil {
+			return result.Error
+		}
+		_, err := changeBalance(tx, chatID, userID, -amount, model.LedgerEntry{
+			Type:          model.LedgerTypeBankLock,
+			Remark:        bankerRemark(banker),
+			ContraAccount: model.LedgerBankAccount(banker.ID),
+		})
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "您还未注册，使用 /register 进行注册。"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if errors.Is(err, errBalanceInsufficient) {
+		msgConfig.Text = fmt.Sprintf("您的余额不足! 坐庄需要锁定%d积分", amount)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("上庄异常:", err)
+		return
+	}
+
+	msgConfig.Text = fmt.Sprintf("上庄成功! 已锁定庄金%d，轮到您时从新开盘的期号起坐庄%d期\n\n%s",
+		amount, banker.MaxIssues, formatBankers(chatID))
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
+
+// handleUnbankCommand 处理 "unbank" 命令，排队中的立即下庄，坐庄中的在已开盘的期号结算后下庄，示例: /unbank
+func handleUnbankCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	banker, err := getUserBanker(chatID, chatMember.User.ID)
+	if err != nil {
+		log.Println("查询庄家异常:", err)
+		return
+	} else if banker == nil {
+		msgConfig.Text = "您没有在坐庄或排队坐庄！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	// 将坐庄期数缩短为已坐庄期数，不再为新期号坐庄
+	result := db.Model(&model.Banker{}).
+		Where("id = ? AND status IN ?", banker.ID, []int{model.BankerStatusWaiting, model.BankerStatusActive}).
+		Updates(map[string]interface{}{
+			"max_issues":  gorm.Expr("issues"),
+			"update_time": time.Now().Format("2006-01-02 15:04:05"),
+		})
+	if result.Error != nil {
+		log.Println("下庄异常:", result.Error)
+		return
+	}
+
+	released, err := releaseBanker(bot, banker.ID)
+	if err != nil {
+		log.Printf("庄家#%d 下庄异常: %s", banker.ID, err.Error())
+		return
+	}
+	if !released {
+		msgConfig.Text = "已申请下庄，坐庄中的期号结算后退还剩余庄金"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+	}
+}
+
+// handleSetBankIssuesCommand 处理 "setbankissues" 命令，设置玩家坐庄的期数，新上庄的庄家生效，示例: /setbankissues 10
+func handleSetBankIssuesCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	issues, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || issues < 1 || issues > maxBankerIssues {
+		msgConfig.Text = fmt.Sprintf("格式错误！坐庄期数须在1至%d之间，示例: /setbankissues 10", maxBankerIssues)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	_, err = model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可设置坐庄期数！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update("banker_issues", issues)
+	if result.Error != nil {
+		log.Println("更新坐庄期数异常", result.Error)
+		return
+	}
+
+	msgConfig.Text = fmt.Sprintf("坐庄期数已修改为%d期，新上庄的庄家生效", issues)
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
+
+// getUserBanker 获取用户排队中或坐庄中的庄家，没有时返回 nil。
+func getUserBanker(chatID int64, userID int64) (*model.Banker, error) {
+	bankers, err := model.ListBankersByChatAndStatus(db, chatID, []int{model.BankerStatusWaiting, model.BankerStatusActive})
+	if err != nil {
+		return nil, err
+	}
+	for _, banker := range bankers {
+		if banker.TgUserID == userID {
+			return banker, nil
+		}
+	}
+	return nil, nil
+}
+
+// assignRoundBanker 为刚开盘且还没有下注的期号指定庄家，优先由坐庄中的庄家继续坐庄，其次按上庄顺序轮换排队的庄家。
+// 返回新指定的庄家，期号已有庄家或没有可坐庄的庄家时返回 nil。
+func assignRoundBanker(round *model.LotteryRound) (*model.Banker, error) {
+	if round.BankerID != 0 {
+		return nil, nil
+	}
+	bankers, err := model.ListBankersByChatAndStatus(db, round.ChatID, []int{model.BankerStatusActive, model.BankerStatusWaiting})
+	if err != nil {
+		return nil, err
+	}
+	// 坐庄中的庄家排在排队的庄家之前
+	var candidates []*model.Banker
+	for _, status := range []int{model.BankerStatusActive, model.BankerStatusWaiting} {
+		for _, banker := range bankers {
+			if banker.Status == status {
+				candidates = append(candidates, banker)
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		var assigned *model.Banker
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// 先锁定期号再锁定庄家，与下注和结算的加锁顺序一致
+			var locked model.LotteryRound
+			result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, round.ID)
+			if result.Error != nil {
+				return result.Error
+			}
+			if locked.BankerID != 0 || locked.Status != model.RoundStatusOpen {
+				return nil
+			}
+			var betCount int64
+			result = tx.Model(&model.BetRecord{}).
+				Where("chat_id = ? AND issue_number = ?", locked.ChatID, locked.IssueNumber).
+				Count(&betCount)
+			if result.Error != nil {
+				return result.Error
+			} else if betCount > 0 {
+				return nil
+			}
+
+			banker, err := model.LockBanker(tx, candidate.ID)
+			if err != nil {
+				return err
+			}
+			if banker.Status == model.BankerStatusReleased || banker.Issues >= banker.MaxIssues || banker.Bankroll <= 0 {
+				return nil
+			}
+
+			updateTime := time.Now().Format("2006-01-02 15:04:05")
+			banker.Issues++
+			banker.Status = model.BankerStatusActive
+			result = tx.Model(banker).Updates(map[string]interface{}{
+				"issues":      banker.Issues,
+				"status":      banker.Status,
+				"update_time": updateTime,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			result = tx.Model(&locked).Updates(map[string]interface{}{
+				"banker_id":   banker.ID,
+				"update_time": updateTime,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			assigned = banker
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if assigned != nil {
+			round.BankerID = assigned.ID
+			return assigned, nil
+		}
+	}
+	return nil, nil
+}
+
+// announceRoundBanker 公布期号的庄家。
+func announceRoundBanker(bot *tgbotapi.BotAPI, round *model.LotteryRound, banker *model.Banker) {
+	msgConfig := tgbotapi.NewMessage(round.ChatID, fmt.Sprintf("第%s期由 %s 坐庄(第%d/%d期)，剩余庄金%d，未结算期号下注的最大赔付合计不超过剩余庄金",
+		round.IssueNumber, bankerMention(banker), banker.Issues, banker.MaxIssues, banker.Bankroll))
+	msgConfig.ParseMode = tgbotapi.ModeHTML
+	_, err := sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, round.ChatID)
+}
+
+// releaseBankers 下庄对话中坐满期数或庄金耗尽的庄家。
+func releaseBankers(bot *tgbotapi.BotAPI, chatID int64) {
+	bankers, err := model.ListBankersByChatAndStatus(db, chatID, []int{model.BankerStatusActive})
+	if err != nil {
+		log.Println("查询庄家异常:", err)
+		return
+	}
+	for _, banker := range bankers {
+		if _, err := releaseBanker(bot, banker.ID); err != nil {
+			log.Printf("庄家#%d 下庄异常: %s", banker.ID, err.Error())
+		}
+	}
+}
+
+// releaseBanker 坐满期数或庄金耗尽且坐庄的期号均已结算或作废时下庄，退还剩余庄金并公布坐庄盈亏，返回是否已下庄。
+func releaseBanker(bot *tgbotapi.BotAPI, bankerID uint) (bool, error) {
+	var released *model.Banker
+	err := db.Transaction(func(tx *gorm.DB) error {
+		banker, err := model.LockBanker(tx, bankerID)
+		if err != nil {
+			return err
+		}
+		if banker.Status == model.BankerStatusReleased || (banker.Issues < banker.MaxIssues && banker.Bankroll > 0) {
+			return nil
+		}
+		count, err := model.CountUnfinishedRoundsByBanker(tx, banker.ID)
+		if err != nil {
+			return err
+		} else if count > 0 {
+			return nil
+		}
+
+		result := tx.Model(banker).Updates(map[string]interface{}{
+			"status":      model.BankerStatusReleased,
+			"update_time": time.Now().Format("2006-01-02 15:04:05"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if banker.Bankroll > 0 {
+			if _, err := changeBalance(tx, banker.ChatID, banker.TgUserID, banker.Bankroll, model.LedgerEntry{
+				Type:          model.LedgerTypeBankBack,
+				Remark:        bankerRemark(banker),
+				ContraAccount: model.LedgerBankAccount(banker.ID),
+			}); err != nil {
+				return err
+			}
+		}
+		released = banker
+		return nil
+	})
+	if err != nil || released == nil {
+		return false, err
+	}
+
+	msgConfig := tgbotapi.NewMessage(released.ChatID, fmt.Sprintf("%s 已下庄，坐庄%d期 盈亏%+d，退还庄金%d",
+		bankerMention(released), released.Issues, released.Bankroll-released.InitialBankroll, released.Bankroll))
+	msgConfig.ParseMode = tgbotapi.ModeHTML
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, released.ChatID)
+	return true, nil
+}
+
+// checkBankerExposure 在事务中校验玩家坐庄期号的下注，加上庄家坐庄的全部未结算期号已有下注后庄家的最大赔付不能超过剩余庄金，
+// 返回下注前剩余可承受的赔付。庄家行在事务结束前保持锁定，同一庄家的下注串行校验。
+func checkBankerExposure(tx *gorm.DB, round *model.LotteryRound, userID int64, betRecords []*model.BetRecord) (int, error) {
+	banker, err := model.LockBanker(tx, round.BankerID)
+	if err != nil {
+		return 0, err
+	}
+	if banker.TgUserID == userID {
+		return 0, errBankerBet
+	}
+	// 剩余庄金只在结算时变更，上一期开奖后未结算期间开盘的期号同样由剩余庄金承担
+	rounds, err := model.ListUnfinishedRoundsByBanker(tx, banker.ID)
+	if err != nil {
+		return 0, err
+	}
+	remaining := banker.Bankroll
+	var current []*model.BetRecord
+	for _, unfinished := range rounds {
+		existing, err := model.ListUnsettledByChatIDAndIssue(tx, unfinished.ChatID, unfinished.IssueNumber)
+		if err != nil {
+			return 0, err
+		}
+		if unfinished.ChatID == round.ChatID && unfinished.IssueNumber == round.IssueNumber {
+			current = existing
+			continue
+		}
+		remaining -= bankerExposure(unfinished.Game, existing)
+	}
+	// 本期的最大赔付与已有下注合并计算，相互对冲的下注(如单和双)不会同时中奖
+	available := remaining - bankerExposure(round.Game, current)
+	if bankerExposure(round.Game, append(current, betRecords...)) > remaining {
+		return available, errBankerExposure
+	}
+	return available, nil
+}
+
+// bankerExposure 计算期号的下注在最不利的开奖结果下庄家需赔付的积分：逐一计算每种开奖结果的派彩合计减去下注本金
+// (计入奖池的积分由庄家承担，不计入本金)，取其中最大值，没有亏损时为 0。
+func bankerExposure(gameKey string, betRecords []*model.BetRecord) int {
+	if len(betRecords) == 0 {
+		return 0
+	}
+	diceGame := game.Of(gameKey)
+	stakes := 0
+	for _, record := range betRecords {
+		stakes += record.BetAmount - record.JackpotAmount
+	}
+	exposure := 0
+	for _, outcome := range diceGame.Outcomes() {
+		payout := 0
+		for _, record := range betRecords {
+			payout += diceGame.Settle(record.BetType, record.BetAmount, recordOdds(record), outcome)
+		}
+		if loss := payout - stakes; loss > exposure {
+			exposure = loss
+		}
+	}
+	return exposure
+}
+
+// roundContraAccount 获取期号中下注、派奖和退还分录的对方账户，玩家坐庄时为庄金账户，否则按分录类型确定。
+func roundContraAccount(round *model.LotteryRound) string {
+	if round == nil || round.BankerID == 0 {
+		return ""
+	}
+	return model.LedgerBankAccount(round.BankerID)
+}
+
+// formatBankers 生成对话中坐庄和排队的庄家列表(HTML)。
+func formatBankers(chatID int64) string {
+	bankers, err := model.ListBankersByChatAndStatus(db, chatID, []int{model.BankerStatusActive, model.BankerStatusWaiting})
+	if err != nil {
+		log.Println("查询庄家异常:", err)
+		return "查询庄家失败"
+	}
+	if len(bankers) == 0 {
+		return "当前由机器人坐庄，使用 /bank <庄金> 上庄"
+	}
+
+	text := ""
+	waiting := 0
+	for _, banker := range bankers {
+		if banker.Status == model.BankerStatusActive {
+			text += fmt.Sprintf("坐庄中: %s 第%d/%d期 剩余庄金%d(上庄%d)\n",
+				bankerMention(banker), banker.Issues, banker.MaxIssues, banker.Bankroll, banker.InitialBankroll)
+		}
+	}
+	for _, banker := range bankers {
+		if banker.Status == model.BankerStatusWaiting {
+			waiting++
+			text += fmt.Sprintf("排队%d: %s 庄金%d 坐庄%d期\n", waiting, bankerMention(banker), banker.Bankroll, banker.MaxIssues)
+		}
+	}
+	return strings.TrimSuffix(text, "\n")
+}
+
+// bankerMention 生成提及庄家的 HTML 链接。
+func bankerMention(banker *model.Banker) string {
+	var user model.TgUser
+	result := db.Where("tg_user_id = ? AND chat_id = ?", banker.TgUserID, banker.ChatID).First(&user)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		log.Println("查询庄家用户异常:", result.Error)
+	}
+	return mentionUser(banker.TgUserID, user.Username)
+}
+
+// bankerRemark 上庄和下庄分录的备注。
+func bankerRemark(banker *model.Banker) string {
+	return fmt.Sprintf("庄家#%d", banker.ID)
+}
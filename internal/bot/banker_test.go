@@ -0,0 +1,27 @@
+package bot
+
+import (
+	"testing"
+
+	"tg-dice-bot/internal/model"
+)
+
+func TestBankerExposureNetsOpposingBets(t *testing.T) {
+	big := &model.BetRecord{BetType: "大", BetAmount: 100, Odds: 2}
+	if exposure := bankerExposure(model.GameDice, []*model.BetRecord{big}); exposure != 100 {
+		t.Fatalf("单注大的最大赔付为%d，应为100", exposure)
+	}
+
+	// 大和小不会同时中奖，一方的派彩由另一方的本金承担
+	small := &model.BetRecord{BetType: "小", BetAmount: 100, Odds: 2}
+	if exposure := bankerExposure(model.GameDice, []*model.BetRecord{big, small}); exposure != 0 {
+		t.Fatalf("大小各100的最大赔付为%d，应为0", exposure)
+	}
+
+	// 计入奖池的积分不计入本金
+	big.JackpotAmount = 10
+	small.JackpotAmount = 10
+	if exposure := bankerExposure(model.GameDice, []*model.BetRecord{big, small}); exposure != 20 {
+		t.Fatalf("扣除奖池后大小各100的最大赔付为%d，应为20", exposure)
+	}
+}
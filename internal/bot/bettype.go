@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+)
+
+// betSlipItem 下注单中的一笔下注
+type betSlipItem struct {
+	betType   string
+	betAmount int
+}
+
+// parseBetSlip 解析一条消息中的全部下注，如 "#单 20 #大 50 #豹子 5"，任意一笔无效则整条消息无效。
+func parseBetSlip(text string) ([]betSlipItem, bool) {
+	parts := strings.Fields(text)
+	if len(parts) == 0 || len(parts)%2 != 0 {
+		return nil, false
+	}
+
+	betSlip := make([]betSlipItem, 0, len(parts)/2)
+	for i := 0; i < len(parts); i += 2 {
+		if !strings.HasPrefix(parts[i], "#") {
+			return nil, false
+		}
+		// 获取下注类型和下注积分，下注类型须为任一游戏的玩法，能否在本期下注由游戏校验
+		_, betType, ok := game.FindByBetType(parts[i][1:])
+		if !ok {
+			return nil, false
+		}
+		betAmount, err := strconv.Atoi(parts[i+1])
+		if err != nil {
+			return nil, false
+		}
+		betSlip = append(betSlip, betSlipItem{betType: betType, betAmount: betAmount})
+	}
+	return betSlip, true
+}
+
+// formatBetSummary 按下注类型汇总本期下注笔数和金额。
+func formatBetSummary(betRecords []*model.BetRecord) string {
+	if len(betRecords) == 0 {
+		return "本期无人下注"
+	}
+
+	counts := make(map[string]int)
+	amounts := make(map[string]int)
+	var betTypes []string
+	for _, record := range betRecords {
+		if _, ok := counts[record.BetType]; !ok {
+			betTypes = append(betTypes, record.BetType)
+		}
+		counts[record.BetType]++
+		amounts[record.BetType] += record.BetAmount
+	}
+	sort.Slice(betTypes, func(i, j int) bool {
+		indexI, indexJ := game.BetTypeIndex(betTypes[i]), game.BetTypeIndex(betTypes[j])
+		if indexI != indexJ {
+			return indexI < indexJ
+		}
+		return betTypes[i] < betTypes[j]
+	})
+
+	text := "本期下注:\n"
+	for _, betType := range betTypes {
+		text += fmt.Sprintf("[%s] %d注 共%d\n", betType, counts[betType], amounts[betType])
+	}
+	return strings.TrimSuffix(text, "\n")
+}
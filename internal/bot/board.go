@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	RedisBetBoardKey = "bet_board:%d" // 下注看板消息，值为 期号:消息ID
+)
+
+const (
+	// betBoardEditInterval 同一对话两次编辑下注看板的最小间隔，避免触发 Telegram 的编辑频率限制
+	betBoardEditInterval = 5 * time.Second
+)
+
+var (
+	betBoardMu     sync.Mutex
+	betBoardStates = make(map[int64]*betBoardState)
+)
+
+// betBoardState 对话下注看板的刷新状态
+type betBoardState struct {
+	lastEdit time.Time // 上次刷新时间
+	pending  bool      // 已安排刷新
+}
+
+// formatBetBoard 生成下注看板，按下注类型统计人数和下注合计。
+func formatBetBoard(issueNumber string, betRecords []*model.BetRecord) string {
+	text := fmt.Sprintf("第%s期下注看板\n", issueNumber)
+	if len(betRecords) == 0 {
+		return text + "本期暂无下注"
+	}
+
+	amounts := make(map[string]int)
+	players := make(map[string]map[int64]bool)
+	allPlayers := make(map[int64]bool)
+	var betTypes []string
+	total := 0
+	for _, record := range betRecords {
+		if _, ok := players[record.BetType]; !ok {
+			players[record.BetType] = make(map[int64]bool)
+			betTypes = append(betTypes, record.BetType)
+		}
+		players[record.BetType][record.TgUserID] = true
+		allPlayers[record.TgUserID] = true
+		amounts[record.BetType] += record.BetAmount
+		total += record.BetAmount
+	}
+	sort.Slice(betTypes, func(i, j int) bool {
+		indexI, indexJ := game.BetTypeIndex(betTypes[i]), game.BetTypeIndex(betTypes[j])
+		if indexI != indexJ {
+			return indexI < indexJ
+		}
+		return betTypes[i] < betTypes[j]
+	})
+
+	for _, betType := range betTypes {
+		text += fmt.Sprintf("[%s] %d人 共%d\n", betType, len(players[betType]), amounts[betType])
+	}
+	text += fmt.Sprintf("合计: %d人 共%d", len(allPlayers), total)
+	return text
+}
+
+// currentBetBoard 生成对话当前期号的下注看板。
+func currentBetBoard(chatID int64) (string, string, error) {
+	issueNumber, err := redisDB.Get(redisDB.Context(), fmt.Sprintf(RedisCurrentIssueKey, chatID)).Result()
+	if err != nil {
+		return "", "", err
+	}
+	betRecords, err := model.GetBetRecordsByChatIDAndIssue(db, chatID, issueNumber)
+	if err != nil {
+		return "", "", err
+	}
+	return issueNumber, formatBetBoard(issueNumber, betRecords), nil
+}
+
+// handleBetsCommand 处理 "bets" 命令，查看当前期号的下注看板。
+func handleBetsCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	_, text, err := currentBetBoard(chatID)
+	if errors.Is(err, redis.Nil) {
+		msgConfig.Text = "当前暂无开奖活动!"
+	} else if err != nil {
+		log.Println("查询下注看板异常:", err)
+		return
+	} else {
+		msgConfig.Text = text
+	}
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
+
+// refreshBetBoard 安排刷新对话的置顶下注看板，同一对话的刷新合并执行且间隔不小于 betBoardEditInterval。
+func refreshBetBoard(bot *tgbotapi.BotAPI, chatID int64) {
+	betBoardMu.Lock()
+	defer betBoardMu.Unlock()
+
+	state, ok := betBoardStates[chatID]
+	if !ok {
+		state = &betBoardState{}
+		betBoardStates[chatID] = state
+	}
+	if state.pending {
+		return
+	}
+	state.pending = true
+
+	delay := time.Until(state.lastEdit.Add(betBoardEditInterval))
+	if delay < 0 {
+		delay = 0
+	}
+	time.AfterFunc(delay, func() {
+		betBoardMu.Lock()
+		state.pending = false
+		state.lastEdit = time.Now()
+		betBoardMu.Unlock()
+
+		updateBetBoard(bot, chatID)
+	})
+}
+
+// updateBetBoard 编辑置顶的下注看板，期号变化时发送新的看板并置顶。
+func updateBetBoard(bot *tgbotapi.BotAPI, chatID int64) {
+	issueNumber, text, err := currentBetBoard(chatID)
+	if errors.Is(err, redis.Nil) {
+		return
+	} else if err != nil {
+		log.Println("查询下注看板异常:", err)
+		return
+	}
+
+	redisKey := fmt.Sprintf(RedisBetBoardKey, chatID)
+	boardIssueNumber, boardMessageID := "", 0
+	if value, err := redisDB.Get(redisDB.Context(), redisKey).Result(); err == nil {
+		if index := strings.LastIndex(value, ":"); index > 0 {
+			boardIssueNumber = value[:index]
+			boardMessageID, _ = strconv.Atoi(value[index+1:])
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		log.Println("获取下注看板异常:", err)
+		return
+	}
+
+	if boardIssueNumber == issueNumber && boardMessageID != 0 {
+		editMsg := tgbotapi.NewEditMessageText(chatID, boardMessageID, text)
+		if _, err := bot.Request(editMsg); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+			log.Println("编辑下注看板异常:", err)
+		}
+		return
+	}
+
+	// 新的期号，发送看板并替换之前的置顶
+	msgConfig := tgbotapi.NewMessage(chatID, text)
+	sentMsg, err := sendMessage(bot, &msgConfig)
+	if err != nil {
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	if err := redisDB.Set(redisDB.Context(), redisKey, fmt.Sprintf("%s:%d", issueNumber, sentMsg.MessageID), 0).Err(); err != nil {
+		log.Println("存储下注看板异常:", err)
+	}
+	if boardMessageID != 0 {
+		if _, err := bot.Request(tgbotapi.UnpinChatMessageConfig{ChatID: chatID, MessageID: boardMessageID}); err != nil {
+			log.Println("取消置顶下注看板异常:", err)
+		}
+	}
+	// 机器人没有置顶权限时只发送看板
+	if _, err := bot.Request(tgbotapi.PinChatMessageConfig{ChatID: chatID, MessageID: sentMsg.MessageID, DisableNotification: true}); err != nil {
+		log.Println("置顶下注看板异常:", err)
+	}
+}
@@ -26,10 +26,24 @@ var (
 func StartBot() {
 	initDB()
 
+	// 为账本启用前注册的用户补记期初余额
+	openLedgerBalances()
+
 	bot := initTelegramBot()
 
+	scheduler = newDiceScheduler(bot)
+	go scheduler.run()
+
+	// 恢复异常中断的期号，须在继续开奖任务之前执行
+	recoverUnsettledBets(bot)
+
+	// 继续等待未应战的对决，退还掷骰中断的对决押金
+	recoverDuels(bot)
+
 	initDiceTask(bot)
 
+	go startReconcileTask(bot)
+
 	updateConfig := tgbotapi.NewUpdate(0)
 	updateConfig.Timeout = 60
 	updates := bot.GetUpdatesChan(updateConfig)
@@ -55,8 +69,20 @@ func initDiceTask(bot *tgbotapi.BotAPI) {
 		redisKey := fmt.Sprintf(RedisCurrentIssueKey, config.ChatID)
 		issueNumberResult := redisDB.Get(redisDB.Context(), redisKey)
 		if errors.Is(issueNumberResult.Err(), redis.Nil) || issueNumberResult == nil {
-			// 没有未开奖的任务，开始新的期号
 			log.Printf("键 %s 不存在", redisKey)
+
+			// 缓存中没有时，以数据库中开盘或封盘的期号为准
+			round, err := model.GetLatestRoundByStatus(db, config.ChatID, []int{model.RoundStatusOpen, model.RoundStatusClosed})
+			if err == nil {
+				log.Printf("有未开奖的任务期号:%s", round.IssueNumber)
+				go resumeDice(bot, config.ChatID, round.IssueNumber)
+				continue
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Println("查询未开奖期号异常:", err)
+				continue
+			}
+
+			// 没有未开奖的任务，开始新的期号
 			issueNumber := time.Now().Format("20060102150405")
 
 			go StartDice(bot, config.ChatID, issueNumber)
@@ -65,10 +91,10 @@ func initDiceTask(bot *tgbotapi.BotAPI) {
 			log.Println("获取值时发生错误:", issueNumberResult.Err())
 			continue
 		} else {
-			// 有未开奖的任务
+			// 有未开奖的任务，按记录的开奖时间继续
 			result, _ := issueNumberResult.Result()
 			log.Printf("有未开奖的任务期号:%s", result)
-			go StartDice(bot, config.ChatID, result)
+			go resumeDice(bot, config.ChatID, result)
 			continue
 		}
 	}
@@ -102,6 +128,31 @@ func initDB() {
 		log.Fatal("自动迁移表结构失败:", err)
 	}
 
+	err = db.AutoMigrate(&model.LotteryRound{})
+	if err != nil {
+		log.Fatal("自动迁移表结构失败:", err)
+	}
+
+	err = db.AutoMigrate(&model.LedgerEntry{})
+	if err != nil {
+		log.Fatal("自动迁移表结构失败:", err)
+	}
+
+	err = db.AutoMigrate(&model.Duel{})
+	if err != nil {
+		log.Fatal("自动迁移表结构失败:", err)
+	}
+
+	err = db.AutoMigrate(&model.Banker{})
+	if err != nil {
+		log.Fatal("自动迁移表结构失败:", err)
+	}
+
+	err = db.AutoMigrate(&model.Jackpot{})
+	if err != nil {
+		log.Fatal("自动迁移表结构失败:", err)
+	}
+
 	redisDB, err = database.InitRedisDB(os.Getenv(database.RedisDBConnectionString))
 	if err != nil {
 		log.Fatal("连接Redis数据库失败:", err)
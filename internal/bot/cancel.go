@@ -0,0 +1,195 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	cancelBetCallbackPrefix = "cancel_bet:"
+)
+
+var (
+	errBettingClosed = errors.New("本期已停止下注")
+	errNoBetToCancel = errors.New("没有可撤销的下注")
+)
+
+// handleCancelCommand 处理 "cancel" 命令，撤销用户本期全部未结算的下注。
+func handleCancelCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	redisKey := fmt.Sprintf(RedisCurrentIssueKey, chatID)
+	issueNumber, err := redisDB.Get(redisDB.Context(), redisKey).Result()
+	if errors.Is(err, redis.Nil) {
+		msgConfig.Text = "当前暂无开奖活动!"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("获取值时发生异常:", err)
+		return
+	}
+
+	msgConfig.Text = cancelBetsReply(chatMember.User.ID, chatID, issueNumber)
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+
+	refreshBetBoard(bot, chatID)
+}
+
+// handleCancelBetQuery 处理下注成功消息上的 "撤销" 按钮。
+func handleCancelBetQuery(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery) {
+	// 回调数据格式: cancel_bet:<期号>:<用户ID>
+	parts := strings.Split(strings.TrimPrefix(callbackQuery.Data, cancelBetCallbackPrefix), ":")
+	if len(parts) != 2 {
+		return
+	}
+	issueNumber := parts[0]
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if callbackQuery.From.ID != userID {
+		answerCallbackQuery(bot, callbackQuery.ID, "只能撤销自己的下注")
+		return
+	}
+
+	chatID := callbackQuery.Message.Chat.ID
+	replyText := cancelBetsReply(userID, chatID, issueNumber)
+	answerCallbackQuery(bot, callbackQuery.ID, replyText)
+
+	// 撤销后移除按钮
+	editMsg := tgbotapi.NewEditMessageTextAndMarkup(chatID, callbackQuery.Message.MessageID,
+		callbackQuery.Message.Text+"\n"+replyText, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+	if _, err := bot.Request(editMsg); err != nil {
+		log.Println("编辑消息异常:", err)
+	}
+
+	refreshBetBoard(bot, chatID)
+}
+
+// answerCallbackQuery 回应回调查询，在客户端弹出提示。
+func answerCallbackQuery(bot *tgbotapi.BotAPI, callbackQueryID string, text string) {
+	if _, err := bot.Request(tgbotapi.NewCallback(callbackQueryID, text)); err != nil {
+		log.Println("回应回调查询异常:", err)
+	}
+}
+
+// cancelBetsReply 撤销用户指定期号的下注并生成回复文本。
+func cancelBetsReply(userID int64, chatID int64, issueNumber string) string {
+	count, refund, err := cancelBets(userID, chatID, issueNumber)
+	if errors.Is(err, errBettingClosed) {
+		return fmt.Sprintf("第%s期已停止下注，无法撤销!", issueNumber)
+	} else if errors.Is(err, errNoBetToCancel) {
+		return fmt.Sprintf("您在第%s期没有可撤销的下注!", issueNumber)
+	} else if err != nil {
+		log.Println("撤销下注异常:", err)
+		return "撤销下注失败，请稍后再试!"
+	}
+	return fmt.Sprintf("已撤销第%s期下注%d笔，退还%d积分", issueNumber, count, refund)
+}
+
+// cancelBets 撤销用户指定期号未结算的下注并退还积分，期号已不是当前期号时拒绝撤销。
+func cancelBets(userID int64, chatID int64, issueNumber string) (count int, refund int, err error) {
+	// 获取用户对应的互斥锁
+	userLock := getUserLock(userID)
+	userLock.Lock()
+	defer userLock.Unlock()
+
+	currentIssueNumber, err := redisDB.Get(redisDB.Context(), fmt.Sprintf(RedisCurrentIssueKey, chatID)).Result()
+	if errors.Is(err, redis.Nil) || (err == nil && currentIssueNumber != issueNumber) {
+		return 0, 0, errBettingClosed
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	// 封盘后不可撤销
+	chatDiceConfig, err := model.GetByChatId(db, chatID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if closed, err := isBettingClosed(chatDiceConfig, issueNumber); err != nil {
+		return 0, 0, err
+	} else if closed {
+		return 0, 0, errBettingClosed
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 共享锁定开盘中的期号，封盘或开奖的状态流转须等待本次撤销提交，已封盘时不再退还
+		var round model.LotteryRound
+		result := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+			Where("chat_id = ? AND issue_number = ? AND status = ?", chatID, issueNumber, model.RoundStatusOpen).
+			First(&round)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return errBettingClosed
+		} else if result.Error != nil {
+			return result.Error
+		}
+
+		betRecords, err := model.ListUnsettledByChatAndUserAndIssue(tx, chatID, userID, issueNumber)
+		if err != nil {
+			return err
+		}
+		if len(betRecords) == 0 {
+			return errNoBetToCancel
+		}
+
+		ids := make([]uint, 0, len(betRecords))
+		jackpot := 0
+		for _, record := range betRecords {
+			ids = append(ids, record.ID)
+			refund += record.BetAmount
+			jackpot += record.JackpotAmount
+		}
+
+		// 仅撤销未结算的记录，避免与开奖结算并发
+		result = tx.Model(&model.BetRecord{}).
+			Where("id IN ? AND settle_status = ?", ids, model.SettleStatusUnsettled).
+			Updates(map[string]interface{}{
+				"settle_status": model.SettleStatusCancelled,
+				"update_time":   time.Now().Format("2006-01-02 15:04:05"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(ids)) {
+			return errBettingClosed
+		}
+
+		// 退还用户积分，每笔下注记一条分录
+		for _, record := range betRecords {
+			_, err := changeBalance(tx, chatID, userID, record.BetAmount, model.LedgerEntry{
+				Type:          model.LedgerTypeRefund,
+				IssueNumber:   issueNumber,
+				BetRecordID:   record.ID,
+				Remark:        "撤销下注",
+				ContraAccount: roundContraAccount(&round),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// 扣回下注时计入奖池的积分
+		if err := model.AddJackpot(tx, chatID, -jackpot); err != nil {
+			return err
+		}
+		count = len(betRecords)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, refund, nil
+}
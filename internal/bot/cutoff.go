@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	RedisCurrentIssueDrawTimeKey = "current_issue_draw_time:%d"
+)
+
+// setIssueDrawTime 记录当前期号的开奖时间。
+func setIssueDrawTime(chatID int64, drawTime time.Time) {
+	redisKey := fmt.Sprintf(RedisCurrentIssueDrawTimeKey, chatID)
+	err := redisDB.Set(redisDB.Context(), redisKey, drawTime.Unix(), 0).Err()
+	if err != nil {
+		log.Println("存储开奖时间异常:", err)
+	}
+}
+
+// getIssueDrawTime 获取当前期号的开奖时间，未记录时返回零值。
+func getIssueDrawTime(chatID int64) (time.Time, error) {
+	redisKey := fmt.Sprintf(RedisCurrentIssueDrawTimeKey, chatID)
+	drawTimeUnix, err := redisDB.Get(redisDB.Context(), redisKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(drawTimeUnix, 0), nil
+}
+
+// isBettingClosed 判断期号是否已封盘：期号不处于开盘状态，或距开奖不足封盘秒数。
+func isBettingClosed(chatDiceConfig *model.ChatDiceConfig, issueNumber string) (bool, error) {
+	round, err := model.GetRound(db, chatDiceConfig.ChatID, issueNumber)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if round.Status != model.RoundStatusOpen {
+		return true, nil
+	}
+
+	drawTime, err := getIssueDrawTime(chatDiceConfig.ChatID)
+	if err != nil {
+		return false, err
+	}
+	if drawTime.IsZero() {
+		return false, nil
+	}
+	closeTime := drawTime.Add(-time.Duration(chatDiceConfig.StopBettingSeconds) * time.Second)
+	return !time.Now().Before(closeTime), nil
+}
+
+// announceBettingClosed 期号流转到封盘状态并发送封盘公告，公平开奖期号同时公布客户端种子。
+func announceBettingClosed(bot *tgbotapi.BotAPI, chatID int64, issueNumber string, drawTime time.Time) {
+	if err := model.TransitionRound(db, chatID, issueNumber, model.RoundStatusClosed); err != nil {
+		log.Printf("第%s期流转到封盘异常: %s", issueNumber, err.Error())
+		return
+	}
+
+	seconds := int(time.Until(drawTime).Round(time.Second).Seconds())
+	msgConfig := tgbotapi.NewMessage(chatID, fmt.Sprintf("第%s期已封盘，停止下注！%d秒后开奖", issueNumber, seconds))
+	_, err := sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+
+	// 公平开奖在封盘时公布客户端种子，失败时开奖前重试
+	round, err := model.GetRound(db, chatID, issueNumber)
+	if err != nil {
+		log.Printf("第%s期查询期号异常: %s", issueNumber, err.Error())
+		return
+	}
+	if round.SeedHash != "" {
+		if err := fixFairClientSeed(bot, round); err != nil {
+			log.Printf("第%s期生成客户端种子异常: %s", issueNumber, err.Error())
+		}
+	}
+}
+
+// handleSetCutoffCommand 处理 "setcutoff" 命令，设置开奖前停止下注的秒数，示例: /setcutoff 10
+func handleSetCutoffCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	seconds, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || seconds < 0 {
+		msgConfig.Text = "格式错误！示例: /setcutoff 10"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	chatDiceConfig, err := model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可设置封盘时间！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	if time.Duration(seconds)*time.Second >= chatDiceConfig.DrawCycle() {
+		msgConfig.Text = "封盘时间须小于开奖周期！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update("stop_betting_seconds", seconds)
+	if result.Error != nil {
+		log.Println("更新封盘时间异常", result.Error)
+		return
+	}
+
+	msgConfig.Text = fmt.Sprintf("已设置开奖前%d秒封盘", seconds)
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+
+	// 当前期号按新的封盘时间封盘，开奖时间不变，已封盘的期号不会重新开盘
+	scheduler.updateCutoff(chatID, time.Duration(seconds)*time.Second)
+}
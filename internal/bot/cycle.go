@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	minDrawCycle = 10 * time.Second
+	maxDrawCycle = 24 * time.Hour
+)
+
+// formatDrawCycle 格式化开奖周期，如 30秒、2分钟、1分30秒。
+func formatDrawCycle(cycle time.Duration) string {
+	seconds := int(cycle.Seconds())
+	hours, minutes, seconds := seconds/3600, seconds%3600/60, seconds%60
+
+	text := ""
+	if hours > 0 {
+		text += fmt.Sprintf("%d小时", hours)
+	}
+	if minutes > 0 {
+		if seconds > 0 {
+			text += fmt.Sprintf("%d分", minutes)
+		} else {
+			text += fmt.Sprintf("%d分钟", minutes)
+		}
+	}
+	if seconds > 0 || text == "" {
+		text += fmt.Sprintf("%d秒", seconds)
+	}
+	return text
+}
+
+// handleSetCycleCommand 处理 "setcycle" 命令，示例: /setcycle 30s、/setcycle 2m
+func handleSetCycleCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	cycle, err := time.ParseDuration(strings.TrimSpace(args))
+	if err != nil || cycle%time.Second != 0 {
+		msgConfig.Text = "格式错误！示例: /setcycle 30s、/setcycle 2m、/setcycle 1m30s"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	if cycle < minDrawCycle || cycle > maxDrawCycle {
+		msgConfig.Text = fmt.Sprintf("开奖周期须在%s至%s之间！", formatDrawCycle(minDrawCycle), formatDrawCycle(maxDrawCycle))
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	chatDiceConfig, err := model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可设置开奖周期！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	if time.Duration(chatDiceConfig.StopBettingSeconds)*time.Second >= cycle {
+		msgConfig.Text = fmt.Sprintf("开奖周期须大于封盘时间%d秒！", chatDiceConfig.StopBettingSeconds)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update("lottery_draw_cycle_second", int(cycle.Seconds()))
+	if result.Error != nil {
+		log.Println("更新开奖周期异常", result.Error)
+		return
+	}
+
+	// 当前期号按已公布的开奖时间开奖，开奖后由调度器按新周期安排下一期
+	msgConfig.Text = fmt.Sprintf("开奖周期已修改为%s，当前期号按原定时间开奖，下一期起生效", formatDrawCycle(cycle))
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"math/big"
+	"strings"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DiceSource 开奖点数来源，开奖流程的其余部分与点数来源无关
+type DiceSource interface {
+	// Roll 为期号投掷 numDice 次 emoji 表情，返回与 Telegram 相同取值范围的值
+	Roll(round *model.LotteryRound, emoji string, numDice int) ([]int, error)
+}
+
+// diceRevealer 开奖后需要公布验证信息的点数来源
+type diceRevealer interface {
+	// Reveal 生成附加在开奖结果后的验证信息
+	Reveal(round *model.LotteryRound) string
+}
+
+// telegramDiceSource 发送 Telegram 骰子表情，以动画结果为点数
+type telegramDiceSource struct {
+	bot    *tgbotapi.BotAPI
+	chatID int64
+}
+
+func (s *telegramDiceSource) Roll(round *model.LotteryRound, emoji string, numDice int) ([]int, error) {
+	diceValues := make([]int, numDice)
+	diceConfig := tgbotapi.NewDiceWithEmoji(s.chatID, emoji)
+
+	for i := 0; i < numDice; i++ {
+		diceMsg, err := s.bot.Send(diceConfig)
+		if err != nil {
+			log.Println("发送骰子消息异常:", err)
+			return nil, err
+		}
+		diceValues[i] = diceMsg.Dice.Value
+	}
+	return diceValues, nil
+}
+
+// cryptoDiceSource 使用本地加密随机数
+type cryptoDiceSource struct{}
+
+func (s *cryptoDiceSource) Roll(round *model.LotteryRound, emoji string, numDice int) ([]int, error) {
+	diceValues := make([]int, numDice)
+	for i := range diceValues {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(game.EmojiMaxValue(emoji))))
+		if err != nil {
+			return nil, err
+		}
+		diceValues[i] = int(n.Int64()) + 1
+	}
+	return diceValues, nil
+}
+
+// fairDiceSource 由期号创建时承诺的服务器种子和停止下注时公布的客户端种子计算点数，开奖后公布服务器种子
+type fairDiceSource struct{}
+
+func (s *fairDiceSource) Roll(round *model.LotteryRound, emoji string, numDice int) ([]int, error) {
+	if round.SeedHash == "" || hashServerSeed(round.ServerSeed) != round.SeedHash || round.ClientSeed == "" {
+		return nil, fmt.Errorf("第%s期公平开奖种子无效", round.IssueNumber)
+	}
+	return fairDiceValues(round.ServerSeed, round.ClientSeed, numDice, game.EmojiMaxValue(emoji)), nil
+}
+
+func (s *fairDiceSource) Reveal(round *model.LotteryRound) string {
+	return formatFairReveal(round)
+}
+
+// errUnknownDrawMode 未知的开奖方式
+var errUnknownDrawMode = errors.New("未知的开奖方式")
+
+// newDiceSource 按开奖方式创建点数来源，未设置和已移除的固定种子开奖方式使用 Telegram 骰子。
+func newDiceSource(bot *tgbotapi.BotAPI, chatID int64, drawMode string) (DiceSource, error) {
+	switch drawMode {
+	case model.DrawModeTelegram, model.DrawModeSeeded, "":
+		return &telegramDiceSource{bot: bot, chatID: chatID}, nil
+	case model.DrawModeCrypto:
+		return &cryptoDiceSource{}, nil
+	case model.DrawModeFair:
+		return &fairDiceSource{}, nil
+	}
+	return nil, errUnknownDrawMode
+}
+
+// roundDiceSource 获取期号的点数来源，开奖方式以期号创建时的对话配置为准。
+func roundDiceSource(bot *tgbotapi.BotAPI, round *model.LotteryRound) (DiceSource, error) {
+	return newDiceSource(bot, round.ChatID, round.DrawMode)
+}
+
+// drawModeNames 开奖方式名称
+var drawModeNames = map[string]string{
+	model.DrawModeTelegram: "Telegram 骰子",
+	model.DrawModeCrypto:   "本地随机数",
+	model.DrawModeFair:     "可验证公平",
+}
+
+// handleSetDrawModeCommand 处理 "setdrawmode" 命令，设置开奖方式，下一期生效，示例: /setdrawmode fair
+func handleSetDrawModeCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	drawMode := strings.ToLower(strings.TrimSpace(args))
+	drawModeName, ok := drawModeNames[drawMode]
+	if !ok {
+		msgConfig.Text = "格式错误！示例: /setdrawmode telegram(Telegram 骰子)、/setdrawmode crypto(本地随机数)、/setdrawmode fair(可验证公平)"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	_, err := model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可设置开奖方式！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update("draw_mode", drawMode)
+	if result.Error != nil {
+		log.Println("更新开奖方式异常", result.Error)
+		return
+	}
+
+	msgConfig.Text = fmt.Sprintf("开奖方式已修改为%s，下一期生效", drawModeName)
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"fmt"
+	"hash/fnv"
+	mathrand "math/rand"
+	"testing"
+
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+)
+
+// seededDiceSource 由固定种子和期号确定点数，同一种子同一期号的结果始终相同，仅用于测试
+type seededDiceSource struct {
+	seed int64
+}
+
+func (s *seededDiceSource) Roll(round *model.LotteryRound, emoji string, numDice int) ([]int, error) {
+	hash := fnv.New64a()
+	hash.Write([]byte(round.IssueNumber))
+	random := mathrand.New(mathrand.NewSource(s.seed ^ int64(hash.Sum64())))
+
+	diceValues := make([]int, numDice)
+	for i := range diceValues {
+		diceValues[i] = random.Intn(game.EmojiMaxValue(emoji)) + 1
+	}
+	return diceValues, nil
+}
+
+// seededRoll 用固定种子为期号开奖
+func seededRoll(t *testing.T, seed int64, round *model.LotteryRound) *model.LotteryRecord {
+	t.Helper()
+	source := &seededDiceSource{seed: seed}
+	record, err := game.Of(round.Game).Roll(func(emoji string, n int) ([]int, error) {
+		return source.Roll(round, emoji, n)
+	})
+	if err != nil {
+		t.Fatalf("第%s期开奖失败: %v", round.IssueNumber, err)
+	}
+	return record
+}
+
+func TestSeededDiceSourceDeterministic(t *testing.T) {
+	round := &model.LotteryRound{IssueNumber: "2024010100001"}
+	for _, emoji := range []string{"🎲", "🏀", "🎰"} {
+		first, err := (&seededDiceSource{seed: 42}).Roll(round, emoji, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := (&seededDiceSource{seed: 42}).Roll(round, emoji, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range first {
+			if first[i] != second[i] {
+				t.Fatalf("%s 同一种子同一期号的点数不同: %v %v", emoji, first, second)
+			}
+			if first[i] < 1 || first[i] > game.EmojiMaxValue(emoji) {
+				t.Fatalf("%s 点数超出范围: %v", emoji, first)
+			}
+		}
+	}
+}
+
+func TestSeededDiceSourceVariesByIssue(t *testing.T) {
+	source := &seededDiceSource{seed: 42}
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		values, err := source.Roll(&model.LotteryRound{IssueNumber: fmt.Sprintf("20240101%05d", i)}, "🎲", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[fmt.Sprint(values)] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("不同期号的点数始终相同")
+	}
+}
+
+func TestSeededRollSettlesDiceBets(t *testing.T) {
+	round := &model.LotteryRound{IssueNumber: "2024010100001", Game: model.GameDice}
+	record := seededRoll(t, 7, round)
+	if again := seededRoll(t, 7, round); *again != *record {
+		t.Fatal("同一种子同一期号的开奖结果不同")
+	}
+
+	// 单双恰有一方中奖，派彩为下注金额乘以赔率
+	diceGame := game.Of(model.GameDice)
+	odd := diceGame.Settle("单", 100, 2, record)
+	even := diceGame.Settle("双", 100, 2, record)
+	if (odd == 0) == (even == 0) || odd+even != 200 {
+		t.Fatalf("开奖结果 %s 的单双派彩异常: 单%d 双%d", diceGame.FormatBrief(record), odd, even)
+	}
+}
+
+func TestNewDiceSourceMapsRemovedSeededMode(t *testing.T) {
+	// 固定种子开奖方式已移除，仍保存该值的对话按默认的 Telegram 骰子开奖
+	source, err := newDiceSource(nil, -100123, model.DrawModeSeeded)
+	if err != nil {
+		t.Fatalf("创建点数来源失败: %v", err)
+	}
+	if _, ok := source.(*telegramDiceSource); !ok {
+		t.Fatalf("点数来源为%T，应为 Telegram 骰子", source)
+	}
+}
@@ -0,0 +1,400 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	duelAcceptCallbackPrefix = "duel_accept:"
+)
+
+const (
+	// duelAcceptTimeout 应战时限，超时未应战自动退还发起方押金
+	duelAcceptTimeout = 60 * time.Second
+	// duelMaxRolls 平局时最多掷骰的轮数，仍为平局则退还双方押金
+	duelMaxRolls = 5
+	// maxDuelFeePercent 对决手续费比例的最大值
+	maxDuelFeePercent = 20
+)
+
+// handleDuelCommand 处理 "duel" 命令，向群内用户发起骰子对决并托管押金，示例: /duel @username 100
+func handleDuelCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	fields := strings.Fields(args)
+	if len(fields) != 2 || !strings.HasPrefix(fields[0], "@") {
+		msgConfig.Text = "格式错误！示例: /duel @username 100"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	amount, err := strconv.Atoi(fields[1])
+	if err != nil || amount <= 0 || amount > math.MaxInt32/2 {
+		msgConfig.Text = "对决积分须为正整数！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	username := strings.TrimPrefix(fields[0], "@")
+
+	var opponent model.TgUser
+	result := db.Where("username = ? AND chat_id = ?", username, chatID).First(&opponent)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		msgConfig.Text = fmt.Sprintf("用户 @%s 未注册！", username)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if result.Error != nil {
+		log.Println("查询异常:", result.Error)
+		return
+	}
+	if opponent.TgUserID == chatMember.User.ID {
+		msgConfig.Text = "不能向自己发起对决！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	// 手续费比例在发起时确定，修改配置不影响进行中的对决
+	feePercent := 0
+	if chatDiceConfig, err := model.GetByChatId(db, chatID); err == nil {
+		feePercent = chatDiceConfig.DuelFeePercent
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	currentTime := time.Now()
+	duel := &model.Duel{
+		ChatID:       chatID,
+		ChallengerID: chatMember.User.ID,
+		OpponentID:   opponent.TgUserID,
+		Amount:       amount,
+		FeePercent:   feePercent,
+		Status:       model.DuelStatusPending,
+		ExpireTime:   currentTime.Add(duelAcceptTimeout).Format("2006-01-02 15:04:05"),
+		UpdateTime:   currentTime.Format("2006-01-02 15:04:05"),
+		CreateTime:   currentTime.Format("2006-01-02 15:04:05"),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(duel); result.Error != nil {
+			return result.Error
+		}
+		_, err := changeBalance(tx, chatID, duel.ChallengerID, -amount, model.LedgerEntry{
+			Type:   model.LedgerTypeDuelHold,
+			Remark: duelRemark(duel),
+		})
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "您还未注册，使用 /register 进行注册。"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if errors.Is(err, errBalanceInsufficient) {
+		msgConfig.Text = fmt.Sprintf("您的余额不足! 本次对决需要%d积分", amount)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("发起对决异常:", err)
+		return
+	}
+
+	msgConfig.Text = formatDuel(duel, fmt.Sprintf("请 %s 在%d秒内点击\"应战\"，超时自动退还押金",
+		mentionUser(opponent.TgUserID, opponent.Username), int(duelAcceptTimeout.Seconds())))
+	msgConfig.ParseMode = tgbotapi.ModeHTML
+	msgConfig.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("应战", fmt.Sprintf("%s%d", duelAcceptCallbackPrefix, duel.ID)),
+		),
+	)
+	sentMsg, err := sendMessage(bot, &msgConfig)
+	if err != nil {
+		delConfigByBlocked(err, chatID)
+	} else {
+		duel.MessageID = sentMsg.MessageID
+		if result := db.Model(duel).Update("message_id", duel.MessageID); result.Error != nil {
+			log.Println("保存对决消息异常:", result.Error)
+		}
+	}
+	scheduleDuelExpiry(bot, duel)
+}
+
+// handleDuelAcceptQuery 处理对决消息上的 "应战" 按钮，托管应战方押金后开始掷骰。
+func handleDuelAcceptQuery(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery) {
+	// 回调数据格式: duel_accept:<对决ID>
+	id, err := strconv.ParseUint(strings.TrimPrefix(callbackQuery.Data, duelAcceptCallbackPrefix), 10, 64)
+	if err != nil {
+		return
+	}
+	duel, err := model.GetDuel(db, uint(id))
+	if err != nil {
+		log.Println("查询对决异常:", err)
+		return
+	}
+	if callbackQuery.From.ID != duel.OpponentID {
+		answerCallbackQuery(bot, callbackQuery.ID, "只有被挑战的用户才能应战")
+		return
+	}
+	expireTime, _ := time.ParseInLocation("2006-01-02 15:04:05", duel.ExpireTime, time.Local)
+	if duel.Status != model.DuelStatusPending || time.Now().After(expireTime) {
+		answerCallbackQuery(bot, callbackQuery.ID, "对决已失效")
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := model.TransitionDuel(tx, duel.ID, model.DuelStatusPending, model.DuelStatusAccepted, nil); err != nil {
+			return err
+		}
+		_, err := changeBalance(tx, duel.ChatID, duel.OpponentID, -duel.Amount, model.LedgerEntry{
+			Type:   model.LedgerTypeDuelHold,
+			Remark: duelRemark(duel),
+		})
+		return err
+	})
+	if errors.Is(err, model.ErrInvalidDuelTransition) {
+		answerCallbackQuery(bot, callbackQuery.ID, "对决已失效")
+		return
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		answerCallbackQuery(bot, callbackQuery.ID, "您还未注册，使用 /register 进行注册。")
+		return
+	} else if errors.Is(err, errBalanceInsufficient) {
+		answerCallbackQuery(bot, callbackQuery.ID, fmt.Sprintf("您的余额不足! 应战需要%d积分", duel.Amount))
+		return
+	} else if err != nil {
+		log.Println("应战异常:", err)
+		return
+	}
+	answerCallbackQuery(bot, callbackQuery.ID, "已应战")
+	duel.Status = model.DuelStatusAccepted
+
+	editDuelMessage(bot, duel, "已应战，开始掷骰...")
+	// 掷骰需等待动画并可能重掷，与开奖结算一样在独立的 goroutine 中进行，应战回调立即返回
+	go playDuel(bot, duel)
+}
+
+// playDuel 由机器人依次为发起方和应战方掷骰，点数大者赢得奖池，平局重掷，掷骰失败或多次平局时退还双方押金。
+func playDuel(bot *tgbotapi.BotAPI, duel *model.Duel) {
+	diceSource := &telegramDiceSource{bot: bot, chatID: duel.ChatID}
+	var diceValues []int
+	for i := 0; i < duelMaxRolls; i++ {
+		values, err := diceSource.Roll(nil, "🎲", 2)
+		if err != nil {
+			delConfigByBlocked(err, duel.ChatID)
+			refundDuel(bot, duel, model.DuelStatusAccepted, "掷骰失败")
+			return
+		}
+		time.Sleep(3 * time.Second)
+		if values[0] != values[1] {
+			diceValues = values
+			break
+		}
+		sendChatNotice(bot, duel.ChatID, fmt.Sprintf("对决#%d 双方均为%d点，重新掷骰", duel.ID, values[0]))
+	}
+	if diceValues == nil {
+		refundDuel(bot, duel, model.DuelStatusAccepted, fmt.Sprintf("连续%d次平局", duelMaxRolls))
+		return
+	}
+
+	winnerID := duel.ChallengerID
+	if diceValues[1] > diceValues[0] {
+		winnerID = duel.OpponentID
+	}
+	pot := duel.Amount * 2
+	fee := pot * duel.FeePercent / 100
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := model.TransitionDuel(tx, duel.ID, model.DuelStatusAccepted, model.DuelStatusFinished, map[string]interface{}{
+			"challenger_value": diceValues[0],
+			"opponent_value":   diceValues[1],
+			"winner_id":        winnerID,
+		})
+		if err != nil {
+			return err
+		}
+		// 获胜方一次入账扣除手续费后的奖池，手续费由托管转入庄家
+		balance, err := changeBalance(tx, duel.ChatID, winnerID, pot-fee, model.LedgerEntry{
+			Type:   model.LedgerTypeDuelWin,
+			Remark: duelRemark(duel),
+		})
+		if err != nil {
+			return err
+		}
+		if fee > 0 {
+			return postSystemTransfer(tx, duel.ChatID, winnerID, model.LedgerAccountEscrow, fee, balance, model.LedgerEntry{
+				Type:   model.LedgerTypeDuelFee,
+				Remark: duelRemark(duel),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("对决#%d 结算异常: %s", duel.ID, err.Error())
+		return
+	}
+
+	usernames := duelUsernames(duel)
+	text := fmt.Sprintf("对决#%d 结果\n%s 🎲%d : %d🎲 %s\n%s 获胜，赢得%d积分",
+		duel.ID,
+		mentionUser(duel.ChallengerID, usernames[duel.ChallengerID]), diceValues[0],
+		diceValues[1], mentionUser(duel.OpponentID, usernames[duel.OpponentID]),
+		mentionUser(winnerID, usernames[winnerID]), pot-fee)
+	if fee > 0 {
+		text += fmt.Sprintf("(奖池%d扣除手续费%d)", pot, fee)
+	}
+	msgConfig := tgbotapi.NewMessage(duel.ChatID, text)
+	msgConfig.ParseMode = tgbotapi.ModeHTML
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, duel.ChatID)
+}
+
+// scheduleDuelExpiry 在应战截止时间退还未应战对决的押金。
+func scheduleDuelExpiry(bot *tgbotapi.BotAPI, duel *model.Duel) {
+	expireTime, err := time.ParseInLocation("2006-01-02 15:04:05", duel.ExpireTime, time.Local)
+	if err != nil {
+		log.Printf("对决#%d 应战截止时间异常: %s", duel.ID, err.Error())
+		return
+	}
+	time.AfterFunc(time.Until(expireTime), func() {
+		refundDuel(bot, duel, model.DuelStatusPending, "超时未应战")
+	})
+}
+
+// refundDuel 将对决从 from 状态流转到已退还并退还已托管的押金，未应战的对决只退还发起方。
+// 状态为条件流转，与应战或结算并发时只有一方生效。
+func refundDuel(bot *tgbotapi.BotAPI, duel *model.Duel, from int, reason string) {
+	userIDs := []int64{duel.ChallengerID}
+	if from == model.DuelStatusAccepted {
+		userIDs = append(userIDs, duel.OpponentID)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := model.TransitionDuel(tx, duel.ID, from, model.DuelStatusRefunded, nil); err != nil {
+			return err
+		}
+		for _, userID := range userIDs {
+			if _, err := changeBalance(tx, duel.ChatID, userID, duel.Amount, model.LedgerEntry{
+				Type:   model.LedgerTypeDuelBack,
+				Remark: duelRemark(duel),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, model.ErrInvalidDuelTransition) {
+		return
+	} else if err != nil {
+		log.Printf("对决#%d 退还押金异常: %s", duel.ID, err.Error())
+		return
+	}
+	editDuelMessage(bot, duel, fmt.Sprintf("%s，已退还押金", reason))
+	if from == model.DuelStatusAccepted {
+		sendChatNotice(bot, duel.ChatID, fmt.Sprintf("对决#%d %s，已退还双方押金各%d积分", duel.ID, reason, duel.Amount))
+	}
+}
+
+// recoverDuels 处理重启前未完成的对决：未应战的继续等待至截止时间，已应战未结算的退还双方押金。
+func recoverDuels(bot *tgbotapi.BotAPI) {
+	duels, err := model.ListDuelsByStatus(db, []int{model.DuelStatusPending, model.DuelStatusAccepted})
+	if err != nil {
+		log.Println("查询未完成的对决异常:", err)
+		return
+	}
+	for _, duel := range duels {
+		if duel.Status == model.DuelStatusPending {
+			scheduleDuelExpiry(bot, duel)
+		} else {
+			refundDuel(bot, duel, model.DuelStatusAccepted, "掷骰中断")
+		}
+	}
+}
+
+// formatDuel 生成对决消息(HTML)。
+func formatDuel(duel *model.Duel, status string) string {
+	usernames := duelUsernames(duel)
+	text := fmt.Sprintf("⚔️ 对决#%d\n%s 向 %s 发起骰子对决，双方各押%d积分，点数大者赢得奖池",
+		duel.ID, mentionUser(duel.ChallengerID, usernames[duel.ChallengerID]), mentionUser(duel.OpponentID, usernames[duel.OpponentID]), duel.Amount)
+	if duel.FeePercent > 0 {
+		text += fmt.Sprintf("(手续费%d%%)", duel.FeePercent)
+	}
+	return text + "\n" + status
+}
+
+// editDuelMessage 更新对决消息的状态并移除按钮。
+func editDuelMessage(bot *tgbotapi.BotAPI, duel *model.Duel, status string) {
+	if duel.MessageID == 0 {
+		return
+	}
+	editMsg := tgbotapi.NewEditMessageTextAndMarkup(duel.ChatID, duel.MessageID, formatDuel(duel, status),
+		tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+	editMsg.ParseMode = tgbotapi.ModeHTML
+	if _, err := bot.Request(editMsg); err != nil {
+		log.Println("编辑对决消息异常:", err)
+	}
+}
+
+// duelUsernames 获取对决双方的用户名。
+func duelUsernames(duel *model.Duel) map[int64]string {
+	usernames := make(map[int64]string, 2)
+	var users []*model.TgUser
+	result := db.Where("chat_id = ? AND tg_user_id IN ?", duel.ChatID, []int64{duel.ChallengerID, duel.OpponentID}).Find(&users)
+	if result.Error != nil {
+		log.Println("查询对决用户异常:", result.Error)
+	}
+	for _, user := range users {
+		usernames[user.TgUserID] = user.Username
+	}
+	return usernames
+}
+
+// duelRemark 对决分录的备注。
+func duelRemark(duel *model.Duel) string {
+	return fmt.Sprintf("对决#%d", duel.ID)
+}
+
+// handleSetDuelFeeCommand 处理 "setduelfee" 命令，设置对决手续费比例，示例: /setduelfee 5
+func handleSetDuelFeeCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	feePercent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(args), "%"))
+	if err != nil || feePercent < 0 || feePercent > maxDuelFeePercent {
+		msgConfig.Text = fmt.Sprintf("格式错误！手续费比例须在0至%d之间，示例: /setduelfee 5", maxDuelFeePercent)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	_, err = model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可设置对决手续费！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update("duel_fee_percent", feePercent)
+	if result.Error != nil {
+		log.Println("更新对决手续费异常", result.Error)
+		return
+	}
+
+	msgConfig.Text = fmt.Sprintf("对决手续费已修改为奖池的%d%%，新发起的对决生效", feePercent)
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
@@ -0,0 +1,208 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"sort"
+	"strings"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+)
+
+// newRoundSeed 生成期号的公平开奖种子：随机服务器种子和其 SHA256 承诺，客户端种子在停止下注时由本期下注生成。
+func newRoundSeed() (*model.RoundSeed, error) {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	serverSeed := hex.EncodeToString(seed)
+	return &model.RoundSeed{
+		ServerSeed: serverSeed,
+		SeedHash:   hashServerSeed(serverSeed),
+	}, nil
+}
+
+// fairClientText 由期号的有效下注生成客户端种子原文：第一行 "群ID:期号"，之后按下注顺序每笔下注一行 "用户ID 下注类型 金额"。
+// 原文只包含玩家在群内可见的下注内容，停止下注时公布，任何人都可以据此计算客户端种子。
+func fairClientText(chatID int64, issueNumber string, betRecords []*model.BetRecord) string {
+	sorted := make([]*model.BetRecord, len(betRecords))
+	copy(sorted, betRecords)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	lines := []string{fmt.Sprintf("%d:%s", chatID, issueNumber)}
+	for _, record := range sorted {
+		lines = append(lines, fmt.Sprintf("%d %s %d", record.TgUserID, record.BetType, record.BetAmount))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fairClientSeed 计算客户端种子原文的 SHA256 作为客户端种子。
+func fairClientSeed(clientText string) string {
+	sum := sha256.Sum256([]byte(clientText))
+	return hex.EncodeToString(sum[:])
+}
+
+// fixFairClientSeed 期号停止下注后由本期下注生成客户端种子，保存到期号并公布原文。
+// 封盘和开奖时都会调用，期号已停止下注，下注不再变化；已保存过的期号沿用已公布的客户端种子，不重复公布。
+func fixFairClientSeed(bot *tgbotapi.BotAPI, round *model.LotteryRound) error {
+	betRecords, err := model.GetBetRecordsByChatIDAndIssue(db, round.ChatID, round.IssueNumber)
+	if err != nil {
+		return err
+	}
+	clientText := fairClientText(round.ChatID, round.IssueNumber, betRecords)
+	clientSeed := fairClientSeed(clientText)
+	saved, err := model.SetRoundClientSeed(db, round.ChatID, round.IssueNumber, clientSeed, clientText)
+	if err != nil {
+		return err
+	}
+	if !saved {
+		saved, err := model.GetRound(db, round.ChatID, round.IssueNumber)
+		if err != nil {
+			return err
+		}
+		round.ClientSeed, round.ClientText = saved.ClientSeed, saved.ClientText
+		return nil
+	}
+	round.ClientSeed, round.ClientText = clientSeed, clientText
+	announceFairClientSeed(bot, round, len(betRecords))
+	return nil
+}
+
+// fairClientTextLimit 单条消息中客户端种子原文的最大长度，超出时分多条消息公布
+const fairClientTextLimit = 3500
+
+// announceFairClientSeed 公布期号的客户端种子和原文，原文过长时按行分多条消息发送。
+func announceFairClientSeed(bot *tgbotapi.BotAPI, round *model.LotteryRound, betCount int) {
+	header := fmt.Sprintf("第%s期 公平开奖客户端种子\n本期共%d笔下注，客户端种子为以下原文(不含本说明)各行以换行连接后的 SHA256:\n%s\n原文:\n",
+		round.IssueNumber, betCount, round.ClientSeed)
+	text := header
+	for _, line := range strings.Split(round.ClientText, "\n") {
+		if len(text)+len(line) > fairClientTextLimit && text != header {
+			msgConfig := tgbotapi.NewMessage(round.ChatID, strings.TrimSuffix(text, "\n"))
+			_, err := sendMessage(bot, &msgConfig)
+			delConfigByBlocked(err, round.ChatID)
+			header = fmt.Sprintf("第%s期 客户端种子原文(续):\n", round.IssueNumber)
+			text = header
+		}
+		text += line + "\n"
+	}
+	msgConfig := tgbotapi.NewMessage(round.ChatID, strings.TrimSuffix(text, "\n"))
+	_, err := sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, round.ChatID)
+}
+
+// hashServerSeed 计算服务器种子的 SHA256 承诺。
+func hashServerSeed(serverSeed string) string {
+	sum := sha256.Sum256([]byte(serverSeed))
+	return hex.EncodeToString(sum[:])
+}
+
+// fairDiceValues 由服务器种子和客户端种子计算 1 至 maxValue 的点数：
+// 以服务器种子为密钥计算 HMAC-SHA256(客户端种子:nonce)，nonce 从 0 开始，
+// 依次取字节，丢弃超出 maxValue 整数倍的字节，其余按 字节%maxValue+1 得到一个点数，字节用完时 nonce 加一继续。
+// 骰子(maxValue 为 6)只取小于 252 的字节。
+func fairDiceValues(serverSeed string, clientSeed string, numDice int, maxValue int) []int {
+	limit := 256 - 256%maxValue
+	values := make([]int, 0, numDice)
+	for nonce := 0; len(values) < numDice; nonce++ {
+		mac := hmac.New(sha256.New, []byte(serverSeed))
+		mac.Write([]byte(fmt.Sprintf("%s:%d", clientSeed, nonce)))
+		for _, b := range mac.Sum(nil) {
+			if int(b) >= limit {
+				continue
+			}
+			values = append(values, int(b)%maxValue+1)
+			if len(values) == numDice {
+				break
+			}
+		}
+	}
+	return values
+}
+
+// announceFairCommitment 公布期号的服务器种子承诺。
+func announceFairCommitment(bot *tgbotapi.BotAPI, round *model.LotteryRound) {
+	msgConfig := tgbotapi.NewMessage(round.ChatID, fmt.Sprintf("第%s期 公平开奖承诺\n服务器种子哈希: %s\n客户端种子: 停止下注时由本期全部下注生成并公布原文\n开奖后公布服务器种子，可使用 /verify %s 验证",
+		round.IssueNumber, round.SeedHash, round.IssueNumber))
+	_, err := sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, round.ChatID)
+}
+
+// formatFairReveal 生成开奖后公布服务器种子和客户端种子的文本。
+func formatFairReveal(round *model.LotteryRound) string {
+	return fmt.Sprintf("服务器种子: %s\n客户端种子: %s(原文已在停止下注时公布)\n验证: /verify %s", round.ServerSeed, round.ClientSeed, round.IssueNumber)
+}
+
+// handleVerifyCommand 处理 "verify" 命令，重新计算公平开奖期号的点数，示例: /verify 20231212120000
+func handleVerifyCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	issueNumber := strings.TrimSpace(args)
+	if issueNumber == "" {
+		msgConfig.Text = "格式错误！示例: /verify 20231212120000"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	round, err := model.GetRound(db, chatID, issueNumber)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && round.SeedHash == "") {
+		msgConfig.Text = fmt.Sprintf("第%s期不是公平开奖期号，无法验证!", issueNumber)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询期号异常:", err)
+		return
+	}
+
+	text := fmt.Sprintf("第%s期公平性验证\n服务器种子哈希: %s\n", issueNumber, round.SeedHash)
+	lotteryRecord, err := model.GetByChatIDAndIssueNumber(db, chatID, issueNumber)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = text + fmt.Sprintf("期号%s，停止下注时公布客户端种子原文，开奖后公布服务器种子", model.RoundStatusName(round.Status))
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖记录异常:", err)
+		return
+	}
+
+	text += fmt.Sprintf("服务器种子: %s\n", round.ServerSeed)
+	if hashServerSeed(round.ServerSeed) == round.SeedHash {
+		text += "SHA256(服务器种子) 与承诺一致 ✅\n"
+	} else {
+		text += "SHA256(服务器种子) 与承诺不一致 ❌\n"
+	}
+	text += fmt.Sprintf("客户端种子: %s\n", round.ClientSeed)
+	if fairClientSeed(round.ClientText) == round.ClientSeed {
+		text += fmt.Sprintf("SHA256(已公布的客户端种子原文，%d笔下注) 与客户端种子一致 ✅\n", strings.Count(round.ClientText, "\n"))
+	} else {
+		text += "SHA256(已公布的客户端种子原文) 与客户端种子不一致 ❌\n"
+	}
+	// 按开奖记录的游戏重新计算并转换为开奖结果
+	diceGame := game.Of(lotteryRecord.Game)
+	computed, _ := diceGame.Roll(func(emoji string, n int) ([]int, error) {
+		return fairDiceValues(round.ServerSeed, round.ClientSeed, n, game.EmojiMaxValue(emoji)), nil
+	})
+	text += fmt.Sprintf("计算结果: %s\n", diceGame.FormatBrief(computed))
+	if computed.ValueA == lotteryRecord.ValueA && computed.ValueB == lotteryRecord.ValueB && computed.ValueC == lotteryRecord.ValueC && computed.Total == lotteryRecord.Total {
+		text += fmt.Sprintf("开奖结果: %s ✅", diceGame.FormatBrief(lotteryRecord))
+	} else {
+		text += fmt.Sprintf("开奖结果: %s ❌", diceGame.FormatBrief(lotteryRecord))
+	}
+
+	msgConfig.Text = text
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
@@ -0,0 +1,26 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"tg-dice-bot/internal/model"
+)
+
+func TestFairClientTextUsesPublishedBets(t *testing.T) {
+	betRecords := []*model.BetRecord{
+		{ID: 12, TgUserID: 2002, BetType: "双", BetAmount: 50},
+		{ID: 7, TgUserID: 1001, BetType: "大", BetAmount: 100},
+	}
+	text := fairClientText(-100123, "2024010100001", betRecords)
+	if want := "-100123:2024010100001\n1001 大 100\n2002 双 50"; text != want {
+		t.Fatalf("客户端种子原文为%q，应为%q", text, want)
+	}
+
+	// 客户端种子只由公布的原文计算
+	sum := sha256.Sum256([]byte(text))
+	if seed := fairClientSeed(text); seed != hex.EncodeToString(sum[:]) {
+		t.Fatalf("客户端种子%s与原文的 SHA256 不一致", seed)
+	}
+}
@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+)
+
+// chatGame 获取对话配置的游戏，对话未开启过时为默认游戏。
+func chatGame(chatDiceConfig *model.ChatDiceConfig) game.Game {
+	if chatDiceConfig == nil {
+		return game.Default()
+	}
+	return game.Of(chatDiceConfig.Game)
+}
+
+// handleSetGameCommand 处理 "setgame" 命令，设置对话的游戏，下一期生效，示例: /setgame slot
+func handleSetGameCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	key := strings.ToLower(strings.TrimSpace(args))
+	diceGame, ok := game.Get(key)
+	if !ok || key == "" {
+		var options []string
+		for _, g := range game.List() {
+			options = append(options, fmt.Sprintf("/setgame %s(%s)", g.Key(), g.Title()))
+		}
+		msgConfig.Text = "格式错误！示例: " + strings.Join(options, "、")
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	_, err := model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可设置游戏！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update("game", diceGame.Key())
+	if result.Error != nil {
+		log.Println("更新游戏异常", result.Error)
+		return
+	}
+
+	msgConfig.Text = fmt.Sprintf("游戏已修改为%s，下一期生效", diceGame.Title())
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
@@ -5,13 +5,13 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"log"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"tg-dice-bot/internal/game"
 	"tg-dice-bot/internal/model"
 )
 
@@ -19,9 +19,9 @@ const (
 	RedisCurrentIssueKey = "current_issue:%d"
 )
 
-var (
-	stopFlags = make(map[int64]chan struct{})
-	stopMutex sync.Mutex
+const (
+	// drawnTransitionRetries 开奖后流转到已开奖的最多尝试次数
+	drawnTransitionRetries = 3
 )
 
 // handleCallbackQuery 处理回调查询。
@@ -29,6 +29,10 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQ
 
 	if callbackQuery.Data == "betting_history" {
 		handleBettingHistoryQuery(bot, callbackQuery)
+	} else if strings.HasPrefix(callbackQuery.Data, cancelBetCallbackPrefix) {
+		handleCancelBetQuery(bot, callbackQuery)
+	} else if strings.HasPrefix(callbackQuery.Data, duelAcceptCallbackPrefix) {
+		handleDuelAcceptQuery(bot, callbackQuery)
 	}
 }
 
@@ -80,12 +84,11 @@ func generateBettingHistoryMessage(records []model.LotteryRecord) string {
 	var msgText string
 
 	for _, record := range records {
-		triplet := ""
-		if record.Triplet == 1 {
-			triplet = "【豹子】"
+		msgText += fmt.Sprintf("%s期: %s", record.IssueNumber, game.Of(record.Game).FormatBrief(&record))
+		if record.JackpotPayout > 0 {
+			msgText += fmt.Sprintf(" 🏆奖池派彩%d", record.JackpotPayout)
 		}
-		msgText += fmt.Sprintf("%s期: %d %d %d  %d  %s  %s %s\n",
-			record.IssueNumber, record.ValueA, record.ValueB, record.ValueC, record.Total, record.SingleDouble, record.BigSmall, triplet)
+		msgText += "\n"
 	}
 	return msgText
 }
@@ -104,9 +107,9 @@ func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 
 	if message.IsCommand() {
 		if message.Chat.IsSuperGroup() || message.Chat.IsGroup() {
-			handleGroupCommand(bot, user.UserName, chatMember, message.Command(), chatID, messageID)
+			handleGroupCommand(bot, user.UserName, chatMember, message.Command(), message.CommandArguments(), chatID, messageID)
 		} else {
-			handlePrivateCommand(bot, chatMember, chatID, messageID, message.Command())
+			handlePrivateCommand(bot, chatMember, chatID, messageID, message.Command(), message.CommandArguments())
 		}
 	} else if message.Text != "" {
 		log.Println("text:" + message.Text)
@@ -117,25 +120,13 @@ func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 // handleBettingCommand 处理下注命令
 func handleBettingCommand(bot *tgbotapi.BotAPI, userID int64, chatID int64, messageID int, text string) {
 
-	// 解析下注命令，示例命令格式：#单 20
-	// 这里需要根据实际需求进行合适的解析，示例中只是简单示范
-	parts := strings.Fields(text)
-	if len(parts) != 2 || !strings.HasPrefix(parts[0], "#") {
+	// 解析下注命令，示例命令格式：#单 20 或 #单 20 #大 50 #豹子 5
+	betSlip, ok := parseBetSlip(text)
+	if !ok {
 		return
 	}
 
-	// 获取下注类型和下注积分
-	betType := parts[0][1:]
-	if betType != "单" && betType != "双" && betType != "大" && betType != "小" && betType != "豹子" {
-		return
-	}
-
-	betAmount, err := strconv.Atoi(parts[1])
-	if err != nil || betAmount <= 0 {
-		return
-	}
-
-	_, err = model.GetByEnableAndChatId(db, 1, chatID)
+	chatDiceConfig, err := model.GetByEnableAndChatId(db, 1, chatID)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		registrationMsg := tgbotapi.NewMessage(chatID, "功能未开启！")
 		registrationMsg.ReplyToMessageID = messageID
@@ -166,8 +157,62 @@ func handleBettingCommand(bot *tgbotapi.BotAPI, userID int64, chatID int64, mess
 
 	issueNumber, _ := issueNumberResult.Result()
 
+	// 由本期的游戏校验下注
+	round, err := model.GetRound(db, chatID, issueNumber)
+	if err != nil {
+		log.Println("获取期号异常:", err)
+		return
+	}
+	diceGame := game.Of(round.Game)
+	for _, bet := range betSlip {
+		err := diceGame.ValidateBet(bet.betType, bet.betAmount)
+		if err == nil {
+			continue
+		}
+		replyMsg := tgbotapi.NewMessage(chatID, fmt.Sprintf("[%s] %d 下注无效: %s", bet.betType, bet.betAmount, err.Error()))
+		if errors.Is(err, game.ErrUnsupportedBet) {
+			replyMsg.Text = fmt.Sprintf("第%s期为%s，不支持下注[%s]，发送 /odds 查看支持的下注种类", issueNumber, diceGame.Title(), bet.betType)
+		}
+		replyMsg.ReplyToMessageID = messageID
+		_, err = bot.Send(replyMsg)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	// 检查是否已封盘
+	closed, err := isBettingClosed(chatDiceConfig, issueNumber)
+	if err != nil {
+		log.Println("获取期号状态异常:", err)
+		return
+	} else if closed {
+		replyMsg := tgbotapi.NewMessage(chatID, fmt.Sprintf("第%s期已封盘，请等待下期开盘后再下注!", issueNumber))
+		replyMsg.ReplyToMessageID = messageID
+		_, err = bot.Send(replyMsg)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	oddsTable := chatOddsTable(chatDiceConfig)
+	currentTime := time.Now().Format("2006-01-02 15:04:05")
+	betRecords := make([]*model.BetRecord, 0, len(betSlip))
+	for _, bet := range betSlip {
+		betRecords = append(betRecords, &model.BetRecord{
+			TgUserID:      userID,
+			ChatID:        chatID,
+			BetType:       bet.betType,
+			BetAmount:     bet.betAmount,
+			Odds:          betOdds(oddsTable, bet.betType),
+			JackpotAmount: jackpotAmount(bet.betAmount, chatDiceConfig.JackpotPercent),
+			IssueNumber:   issueNumber,
+			SettleStatus:  model.SettleStatusUnsettled,
+			BetResultType: nil,
+			UpdateTime:    currentTime,
+			CreateTime:    currentTime,
+		})
+	}
+
 	// 存储下注记录到数据库，并扣除用户余额
-	err = storeBetRecord(bot, userID, chatID, issueNumber, messageID, betType, betAmount)
+	err = storeBetRecord(bot, userID, chatID, messageID, betRecords)
 	if err != nil {
 		// 回复余额不足信息等
 		log.Println("存储下注记录异常:", err)
@@ -175,107 +220,222 @@ func handleBettingCommand(bot *tgbotapi.BotAPI, userID int64, chatID int64, mess
 	}
 
 	// 回复下注成功信息
-	replyMsg := tgbotapi.NewMessage(chatID, "下注成功!")
+	replyMsg := tgbotapi.NewMessage(chatID, formatBetSlipReply(issueNumber, betRecords))
 	replyMsg.ReplyToMessageID = messageID
+	replyMsg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("撤销", fmt.Sprintf("%s%s:%d", cancelBetCallbackPrefix, issueNumber, userID)),
+		),
+	)
 
 	_, err = bot.Send(replyMsg)
 	if err != nil {
 		log.Println("发送消息异常:", err)
 		delConfigByBlocked(err, chatID)
 	}
+
+	refreshBetBoard(bot, chatID)
 }
 
-// storeBetRecord 函数中扣除用户余额并保存下注记录
-func storeBetRecord(bot *tgbotapi.BotAPI, userID int64, chatID int64, issueNumber string, messageID int, betType string, betAmount int) error {
-	// 获取用户对应的互斥锁
-	userLock := getUserLock(userID)
-	userLock.Lock()
-	defer userLock.Unlock()
+// formatBetSlipReply 生成下注成功回复，汇总本条消息的全部下注。
+func formatBetSlipReply(issueNumber string, betRecords []*model.BetRecord) string {
+	text := fmt.Sprintf("下注成功! 第%s期\n", issueNumber)
+	total := 0
+	for _, record := range betRecords {
+		text += fmt.Sprintf("[%s] %d (赔率%s)\n", record.BetType, record.BetAmount, game.FormatOdds(record.Odds))
+		total += record.BetAmount
+	}
+	if len(betRecords) > 1 {
+		text += fmt.Sprintf("合计: %d", total)
+	}
+	return strings.TrimSuffix(text, "\n")
+}
 
-	// 获取用户信息
-	var user model.TgUser
-	result := db.Where("tg_user_id = ? AND chat_id = ?", userID, chatID).First(&user)
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+// storeBetRecord 函数中扣除用户余额并保存下注记录，同一条消息中的下注要么全部成功要么全部失败。
+// 扣款与保存下注记录在同一事务中，扣款为条件更新并锁定用户行，多个机器人实例同时下注也不会超扣。
+// 下注记录中计入奖池的积分在同一事务中加入对话的奖池。
+func storeBetRecord(bot *tgbotapi.BotAPI, userID int64, chatID int64, messageID int, betRecords []*model.BetRecord) error {
+	totalAmount, jackpotTotal := 0, 0
+	for _, record := range betRecords {
+		totalAmount += record.BetAmount
+		jackpotTotal += record.JackpotAmount
+	}
+	issueNumber := betRecords[0].IssueNumber
+
+	// 玩家坐庄时下注前剩余可承受的赔付
+	bankerRemaining := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 共享锁定开盘中的期号，封盘或开奖的状态流转须等待本次下注提交
+		var round model.LotteryRound
+		result := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+			Where("chat_id = ? AND issue_number = ? AND status = ?", chatID, issueNumber, model.RoundStatusOpen).
+			First(&round)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return errBettingClosed
+		} else if result.Error != nil {
+			return result.Error
+		}
+
+		// 玩家坐庄的期号，下注的最大赔付不能超过庄家剩余庄金
+		if round.BankerID != 0 {
+			var err error
+			if bankerRemaining, err = checkBankerExposure(tx, &round, userID, betRecords); err != nil {
+				return err
+			}
+		}
+
+		// 保存下注记录
+		result = tx.Create(&betRecords)
+		if result.Error != nil {
+			log.Println("保存下注记录异常:", result.Error)
+			return result.Error
+		}
+
+		// 逐笔扣除用户余额并记录分录，任一笔余额不足或用户不存在时整体回滚
+		for _, record := range betRecords {
+			_, err := changeBalance(tx, chatID, userID, -record.BetAmount, model.LedgerEntry{
+				Type:          model.LedgerTypeStake,
+				IssueNumber:   record.IssueNumber,
+				BetRecordID:   record.ID,
+				Remark:        record.BetType,
+				ContraAccount: roundContraAccount(&round),
+			})
+			if err != nil {
+				if !errors.Is(err, errBalanceInsufficient) && !errors.Is(err, gorm.ErrRecordNotFound) {
+					log.Println("扣除用户余额异常:", err)
+				}
+				return err
+			}
+		}
+
+		// 按比例计入奖池
+		return model.AddJackpot(tx, chatID, jackpotTotal)
+	})
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		// 用户不存在，发送注册提示
 		registrationMsg := tgbotapi.NewMessage(chatID, "您还未注册，使用 /register 进行注册。")
 		registrationMsg.ReplyToMessageID = messageID
-		_, err := bot.Send(registrationMsg)
-		if err != nil {
-			log.Println("发送注册提示消息异常:", err)
-			delConfigByBlocked(err, chatID)
-			return err
+		_, sendErr := bot.Send(registrationMsg)
+		if sendErr != nil {
+			log.Println("发送注册提示消息异常:", sendErr)
+			delConfigByBlocked(sendErr, chatID)
 		}
-		return result.Error
-	}
-
-	// 检查用户余额是否足够
-	if user.Balance < betAmount {
-		// 用户不存在，发送注册提示
-		balanceInsufficientMsg := tgbotapi.NewMessage(chatID, "您的余额不足!")
+	} else if errors.Is(err, errBettingClosed) {
+		replyMsg := tgbotapi.NewMessage(chatID, fmt.Sprintf("第%s期已封盘，请等待下期开盘后再下注!", issueNumber))
+		replyMsg.ReplyToMessageID = messageID
+		_, sendErr := bot.Send(replyMsg)
+		delConfigByBlocked(sendErr, chatID)
+	} else if errors.Is(err, errBalanceInsufficient) {
+		balanceInsufficientMsg := tgbotapi.NewMessage(chatID, fmt.Sprintf("您的余额不足! 本次下注合计%d", totalAmount))
 		balanceInsufficientMsg.ReplyToMessageID = messageID
-		_, err := bot.Send(balanceInsufficientMsg)
-		if err != nil {
-			log.Println("您的余额不足提示异常:", err)
-			delConfigByBlocked(err, chatID)
-			return err
-		} else {
-			return errors.New("余额不足")
+		_, sendErr := bot.Send(balanceInsufficientMsg)
+		if sendErr != nil {
+			log.Println("您的余额不足提示异常:", sendErr)
+			delConfigByBlocked(sendErr, chatID)
 		}
+	} else if errors.Is(err, errBankerExposure) {
+		replyMsg := tgbotapi.NewMessage(chatID, fmt.Sprintf("超出庄家可承受的赔付! 第%s期剩余可赔付%d，请减少下注", issueNumber, bankerRemaining))
+		replyMsg.ReplyToMessageID = messageID
+		_, sendErr := bot.Send(replyMsg)
+		delConfigByBlocked(sendErr, chatID)
+	} else if errors.Is(err, errBankerBet) {
+		replyMsg := tgbotapi.NewMessage(chatID, fmt.Sprintf("您是第%s期的庄家，不能在本期下注!", issueNumber))
+		replyMsg.ReplyToMessageID = messageID
+		_, sendErr := bot.Send(replyMsg)
+		delConfigByBlocked(sendErr, chatID)
 	}
-
-	// 扣除用户余额
-	user.Balance -= betAmount
-	result = db.Save(&user)
-	if result.Error != nil {
-		log.Println("扣除用户余额异常:", result.Error)
-		return result.Error
-	}
-	currentTime := time.Now().Format("2006-01-02 15:04:05")
-	// 保存下注记录
-	betRecord := model.BetRecord{
-		TgUserID:      userID,
-		ChatID:        chatID,
-		BetType:       betType,
-		BetAmount:     betAmount,
-		IssueNumber:   issueNumber,
-		SettleStatus:  0,
-		BetResultType: nil,
-		UpdateTime:    currentTime,
-		CreateTime:    currentTime,
-	}
-
-	result = db.Create(&betRecord)
-	if result.Error != nil {
-		log.Println("保存下注记录异常:", result.Error)
-		// 如果保存下注记录失败，需要返还用户余额
-		user.Balance += betAmount
-		db.Save(&user)
-		return result.Error
-	}
-
-	return nil
+	return err
 }
 
 // handleGroupCommand 处理群聊中的命令。
-func handleGroupCommand(bot *tgbotapi.BotAPI, username string, chatMember tgbotapi.ChatMember, command string, chatID int64, messageID int) {
+func handleGroupCommand(bot *tgbotapi.BotAPI, username string, chatMember tgbotapi.ChatMember, command string, args string, chatID int64, messageID int) {
 	if command == "start" {
-		if !chatMember.IsAdministrator() && !chatMember.IsCreator() {
-			msgConfig := tgbotapi.NewMessage(chatID, "请勿使用管理员命令")
-			msgConfig.ReplyToMessageID = messageID
-			_, err := sendMessage(bot, &msgConfig)
-			delConfigByBlocked(err, chatID)
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
 			return
 		}
 		handleStartCommand(bot, chatID, messageID)
 	} else if command == "stop" {
-		if !chatMember.IsAdministrator() && !chatMember.IsCreator() {
-			msgConfig := tgbotapi.NewMessage(chatID, "请勿使用管理员命令")
-			msgConfig.ReplyToMessageID = messageID
-			_, err := sendMessage(bot, &msgConfig)
-			delConfigByBlocked(err, chatID)
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
 			return
 		}
 		handleStopCommand(bot, chatID, messageID)
+	} else if command == "setodds" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetOddsCommand(bot, chatID, messageID, args)
+	} else if command == "setcycle" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetCycleCommand(bot, chatID, messageID, args)
+	} else if command == "setcutoff" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetCutoffCommand(bot, chatID, messageID, args)
+	} else if command == "void" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleVoidCommand(bot, chatID, messageID, args)
+	} else if command == "setsummary" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetSummaryCommand(bot, chatID, messageID, args)
+	} else if command == "summarydm" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSummaryDMCommand(bot, chatID, messageID, args)
+	} else if command == "setdrawmode" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetDrawModeCommand(bot, chatID, messageID, args)
+	} else if command == "setgame" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetGameCommand(bot, chatID, messageID, args)
+	} else if command == "verify" {
+		handleVerifyCommand(bot, chatID, messageID, args)
+	} else if command == "adjust" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleAdjustCommand(bot, chatID, messageID, args)
+	} else if command == "setduelfee" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetDuelFeeCommand(bot, chatID, messageID, args)
+	} else if command == "duel" {
+		handleDuelCommand(bot, chatMember, chatID, messageID, args)
+	} else if command == "setbankissues" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetBankIssuesCommand(bot, chatID, messageID, args)
+	} else if command == "setjackpot" {
+		if !checkAdmin(bot, chatMember, chatID, messageID) {
+			return
+		}
+		handleSetJackpotCommand(bot, chatID, messageID, args)
+	} else if command == "bank" {
+		handleBankCommand(bot, chatMember, chatID, messageID, args)
+	} else if command == "unbank" {
+		handleUnbankCommand(bot, chatMember, chatID, messageID)
+	} else if command == "ledger" {
+		handleLedgerCommand(bot, chatMember, chatID, messageID)
+	} else if command == "odds" {
+		handleOddsCommand(bot, chatID, messageID)
+	} else if command == "bets" {
+		handleBetsCommand(bot, chatID, messageID)
+	} else if command == "cancel" {
+		handleCancelCommand(bot, chatMember, chatID, messageID)
 	} else if command == "register" {
 		handleRegisterCommand(bot, chatMember, chatID, messageID)
 	} else if command == "sign" {
@@ -292,6 +452,18 @@ func handleGroupCommand(bot *tgbotapi.BotAPI, username string, chatMember tgbota
 
 }
 
+// checkAdmin 检查是否为管理员，非管理员时回复提示。
+func checkAdmin(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int) bool {
+	if chatMember.IsAdministrator() || chatMember.IsCreator() {
+		return true
+	}
+	msgConfig := tgbotapi.NewMessage(chatID, "请勿使用管理员命令")
+	msgConfig.ReplyToMessageID = messageID
+	_, err := sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+	return false
+}
+
 func handleRegisterCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int) {
 	// 获取用户对应的互斥锁
 	userLock := getUserLock(chatMember.User.ID)
@@ -357,12 +529,21 @@ func handleSignInCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, c
 				return
 			}
 		}
-		user.SignInTime = time.Now().Format("2006-01-02 15:04:05")
-		user.Balance += 1000
-		result = db.Save(&user)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&user).Update("sign_in_time", time.Now().Format("2006-01-02 15:04:05"))
+			if result.Error != nil {
+				return result.Error
+			}
+			_, err := changeBalance(tx, chatID, user.TgUserID, 1000, model.LedgerEntry{Type: model.LedgerTypeSignIn})
+			return err
+		})
+		if err != nil {
+			log.Println("签到异常:", err)
+			return
+		}
 		msgConfig := tgbotapi.NewMessage(chatID, "签到成功！奖励1000积分！")
 		msgConfig.ReplyToMessageID = messageID
-		_, err := sendMessage(bot, &msgConfig)
+		_, err = sendMessage(bot, &msgConfig)
 		delConfigByBlocked(err, chatID)
 	}
 }
@@ -430,7 +611,7 @@ func handlePoorCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, cha
 		var betRecord model.BetRecord
 		betRecord.ChatID = chatID
 		betRecord.TgUserID = chatMember.User.ID
-		betRecord.SettleStatus = 0
+		betRecord.SettleStatus = model.SettleStatusUnsettled
 		betRecords, err := model.ListBySettleStatus(db, &betRecord)
 
 		if len(betRecords) == 0 {
@@ -442,11 +623,17 @@ func handlePoorCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, cha
 				delConfigByBlocked(err, chatID)
 				return
 			}
-			user.Balance += 1000
-			result = db.Save(&user)
+			err := db.Transaction(func(tx *gorm.DB) error {
+				_, err := changeBalance(tx, chatID, user.TgUserID, 1000, model.LedgerEntry{Type: model.LedgerTypePoor})
+				return err
+			})
+			if err != nil {
+				log.Println("领取低保异常:", err)
+				return
+			}
 			msgConfig := tgbotapi.NewMessage(chatID, "领取低保成功！获得1000积分！")
 			msgConfig.ReplyToMessageID = messageID
-			_, err := sendMessage(bot, &msgConfig)
+			_, err = sendMessage(bot, &msgConfig)
 			delConfigByBlocked(err, chatID)
 			return
 		} else if err != nil {
@@ -464,19 +651,23 @@ func handlePoorCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, cha
 // registerUser 函数用于用户注册时插入初始数据到数据库
 func registerUser(userID int64, userName string, chatID int64) error {
 	initialBalance := 1000
-	newUser := model.TgUser{
-		TgUserID: userID,
-		ChatID:   chatID,
-		Username: userName,
-		Balance:  initialBalance,
-	}
-
-	result := db.Create(&newUser)
-	return result.Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		newUser := model.TgUser{
+			TgUserID: userID,
+			ChatID:   chatID,
+			Username: userName,
+		}
+		result := tx.Create(&newUser)
+		if result.Error != nil {
+			return result.Error
+		}
+		_, err := changeBalance(tx, chatID, userID, initialBalance, model.LedgerEntry{Type: model.LedgerTypeRegister})
+		return err
+	})
 }
 
 // handlePrivateCommand 处理私聊中的命令。
-func handlePrivateCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int, command string) {
+func handlePrivateCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int, command string, args string) {
 	switch command {
 	case "stop":
 		handleStopCommand(bot, chatID, messageID)
@@ -494,6 +685,38 @@ func handlePrivateCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember,
 		handlePoorCommand(bot, chatMember, chatID, messageID)
 	case "myhistory":
 		handleMyHistoryCommand(bot, chatMember, chatID, messageID)
+	case "ledger":
+		handleLedgerCommand(bot, chatMember, chatID, messageID)
+	case "odds":
+		handleOddsCommand(bot, chatID, messageID)
+	case "bets":
+		handleBetsCommand(bot, chatID, messageID)
+	case "cancel":
+		handleCancelCommand(bot, chatMember, chatID, messageID)
+	case "setodds":
+		handleSetOddsCommand(bot, chatID, messageID, args)
+	case "setcutoff":
+		handleSetCutoffCommand(bot, chatID, messageID, args)
+	case "setcycle":
+		handleSetCycleCommand(bot, chatID, messageID, args)
+	case "setdrawmode":
+		handleSetDrawModeCommand(bot, chatID, messageID, args)
+	case "setgame":
+		handleSetGameCommand(bot, chatID, messageID, args)
+	case "setduelfee":
+		handleSetDuelFeeCommand(bot, chatID, messageID, args)
+	case "setbankissues":
+		handleSetBankIssuesCommand(bot, chatID, messageID, args)
+	case "setjackpot":
+		handleSetJackpotCommand(bot, chatID, messageID, args)
+	case "verify":
+		handleVerifyCommand(bot, chatID, messageID, args)
+	case "setsummary":
+		handleSetSummaryCommand(bot, chatID, messageID, args)
+	case "summarydm":
+		handleSummaryDMCommand(bot, chatID, messageID, args)
+	case "void":
+		handleVoidCommand(bot, chatID, messageID, args)
 	}
 }
 
@@ -539,9 +762,13 @@ func handleStartCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
 	if errors.Is(chatDiceConfigResult.Error, gorm.ErrRecordNotFound) {
 		// 开奖配置不存在 则保存
 		chatDiceConfig = &model.ChatDiceConfig{
-			ChatID:           chatID,
-			LotteryDrawCycle: 1, // 开奖周期(分钟)
-			Enable:           1, // 开启状态
+			ChatID:             chatID,
+			LotteryDrawCycle:   1,                      // 开奖周期(分钟)
+			StopBettingSeconds: 10,                     // 开奖前封盘秒数
+			Enable:             1,                      // 开启状态
+			SummaryWinnerLimit: 10,                     // 结算公告中奖名单上限
+			DrawMode:           model.DrawModeTelegram, // 开奖方式
+			Game:               model.GameDice,         // 游戏
 		}
 		db.Create(&chatDiceConfig)
 	} else if chatDiceConfigResult.Error != nil {
@@ -570,38 +797,32 @@ func handleStartCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
 	redisKey := fmt.Sprintf(RedisCurrentIssueKey, chatID)
 	issueNumberResult := redisDB.Get(redisDB.Context(), redisKey)
 	if errors.Is(issueNumberResult.Err(), redis.Nil) || issueNumberResult == nil {
-		lotteryDrawTipMsgConfig := tgbotapi.NewMessage(chatID, fmt.Sprintf("第%s期 %d分钟后开奖", issueNumber, chatDiceConfig.LotteryDrawCycle))
-		_, err := sendMessage(bot, &lotteryDrawTipMsgConfig)
-		if err != nil {
-			delConfigByBlocked(err, chatID)
-			return
-		}
-		// 存储当前期号和对话ID
-		err = redisDB.Set(redisDB.Context(), redisKey, issueNumber, 0).Err()
-		if err != nil {
-			log.Println("存储新期号和对话ID异常:", err)
-			return
-		}
+		log.Printf("键 %s 不存在", redisKey)
 	} else if issueNumberResult.Err() != nil {
 		log.Println("获取值时发生异常:", issueNumberResult.Err())
 		return
 	} else {
 		result, _ := issueNumberResult.Result()
 		issueNumber = result
-		lotteryDrawTipMsgConfig := tgbotapi.NewMessage(chatID, fmt.Sprintf("第%s期 %d分钟后开奖", issueNumber, chatDiceConfig.LotteryDrawCycle))
-		_, err := sendMessage(bot, &lotteryDrawTipMsgConfig)
-		if err != nil {
-			delConfigByBlocked(err, chatID)
-			return
-		}
 	}
 
-	//redisKey := fmt.Sprintf(RedisCurrentIssueKey, chatID)
-	go StartDice(bot, chatID, issueNumber)
+	// 由调度器存储期号并提示开奖时间
+	StartDice(bot, chatID, issueNumber)
 }
 
 // handleHelpCommand 处理 "help" 命令。
 func handleHelpCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
+	chatDiceConfig, err := model.GetByChatId(db, chatID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	// 未开启过的对话按 /start 创建配置时的默认周期展示
+	drawCycle := time.Minute
+	if chatDiceConfig != nil {
+		drawCycle = chatDiceConfig.DrawCycle()
+	}
 	msgConfig := tgbotapi.NewMessage(chatID, "/help 帮助\n"+
 		"/start 开启\n"+
 		"/stop 关闭\n"+
@@ -610,8 +831,30 @@ func handleHelpCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
 		"/my 查询积分\n"+
 		"/myhistory 查询历史下注记录\n"+
 		"/iampoor 领取低保\n"+
+		"/ledger 查询积分变动\n"+
+		"/cancel 撤销本期下注\n"+
+		"/bets 查询本期下注看板\n"+
+		"/duel 向群内用户发起骰子对决，如 /duel @username 100\n"+
+		"/bank 锁定庄金排队坐庄，如 /bank 10000，不带参数时查询庄家\n"+
+		"/unbank 下庄并退还剩余庄金\n"+
+		"/verify 验证公平开奖期号，如 /verify 20231212120000\n"+
+		"/odds 查询赔率\n"+
+		"/setodds 设置赔率(管理员)\n"+
+		"/setcutoff 设置开奖前封盘秒数(管理员)\n"+
+		"/setcycle 设置开奖周期(管理员)，如 30s、2m\n"+
+		"/void 作废期号并退还下注(管理员)，不填期号时作废当前期号\n"+
+		"/adjust 调整用户积分(管理员)，如 /adjust @username 500\n"+
+		"/setdrawmode 设置开奖方式(管理员)，telegram、crypto 或 fair\n"+
+		"/setgame 设置游戏(管理员)，dice、dart、basketball、football、bowling 或 slot\n"+
+		"/setduelfee 设置对决手续费比例(管理员)，如 /setduelfee 5\n"+
+		"/setbankissues 设置玩家坐庄期数(管理员)，如 /setbankissues 10\n"+
+		"/setjackpot 设置下注计入奖池的比例(管理员)，如 /setjackpot 2\n"+
+		"/setsummary 设置结算公告中奖名单上限(管理员)，如 /setsummary 10\n"+
+		"/summarydm 开关结算私聊通知(管理员)，如 /summarydm on\n"+
 		"玩法例子(竞猜-单,下注-20): #单 20\n"+
-		"默认开奖周期: 1分钟")
+		"一条消息多笔下注: #单 20 #大 50 #豹子 5\n"+
+		"开奖周期: "+formatDrawCycle(drawCycle)+"\n"+
+		chatGame(chatDiceConfig).Help(chatOddsTable(chatDiceConfig)))
 	msgConfig.ReplyToMessageID = messageID
 	sentMsg, err := sendMessage(bot, &msgConfig)
 	if err != nil {
@@ -652,17 +895,31 @@ func handleMyHistoryCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember
 		log.Println("查询下注记录异常", err)
 		return
 	} else {
+		// 查询下注期号对应的开奖结果
+		issueNumbers := make([]string, 0, len(betRecords))
+		for _, record := range betRecords {
+			issueNumbers = append(issueNumbers, record.IssueNumber)
+		}
+		lotteryRecords, err := model.ListByChatIDAndIssueNumbers(db, chatID, issueNumbers)
+		if err != nil {
+			log.Println("查询开奖记录异常", err)
+			return
+		}
+
 		msgText := "您的下注记录如下:\n"
 
 		for _, record := range betRecords {
 			betResultType := ""
 			betResultAmount := ""
-			if record.BetResultType != nil {
+			if record.SettleStatus == model.SettleStatusCancelled {
+				betResultType = "[已撤销]"
+			} else if record.SettleStatus == model.SettleStatusRefunded {
+				betResultType = "[已退还]"
+			} else if record.BetResultType != nil {
 				if *record.BetResultType == 1 {
-					if record.BetType == "单" || record.BetType == "双" || record.BetType == "大" || record.BetType == "小" {
-						betResultAmount = fmt.Sprintf("+%d", record.BetAmount*2)
-					} else if record.BetType == "豹子" {
-						betResultAmount = fmt.Sprintf("+%d", record.BetAmount*10)
+					if lotteryRecord, ok := lotteryRecords[record.IssueNumber]; ok {
+						payout := game.Of(lotteryRecord.Game).Settle(record.BetType, record.BetAmount, recordOdds(record), lotteryRecord)
+						betResultAmount = fmt.Sprintf("+%d", payout)
 					}
 					betResultType = "赢"
 				} else if *record.BetResultType == 0 {
@@ -706,6 +963,13 @@ func sendMessage(bot *tgbotapi.BotAPI, msgConfig *tgbotapi.MessageConfig) (tgbot
 	return sentMsg, nil
 }
 
+// sendChatNotice 向对话发送通知消息。
+func sendChatNotice(bot *tgbotapi.BotAPI, chatID int64, text string) {
+	msgConfig := tgbotapi.NewMessage(chatID, text)
+	_, err := sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
+
 // getChatMember 获取有关聊天成员的信息。
 func getChatMember(bot *tgbotapi.BotAPI, chatID int64, userID int) (tgbotapi.ChatMember, error) {
 	chatMemberConfig := tgbotapi.ChatConfigWithUser{
@@ -716,64 +980,19 @@ func getChatMember(bot *tgbotapi.BotAPI, chatID int64, userID int) (tgbotapi.Cha
 	return bot.GetChatMember(tgbotapi.GetChatMemberConfig{ChatConfigWithUser: chatMemberConfig})
 }
 
-// stopDice 停止特定聊天ID的骰子滚动。
-func stopDice(chatID int64) {
-	chatLock := getChatLock(chatID)
-	chatLock.Lock()
-	defer chatLock.Unlock()
-
-	if stopFlag, ok := stopFlags[chatID]; ok {
-		log.Printf("停止聊天ID的任务：%v", chatID)
-		close(stopFlag)
-		delete(stopFlags, chatID)
-	} else {
-		log.Printf("没有要停止的聊天ID的任务：%v", chatID)
-	}
-}
-
-// startDice 启动特定聊天ID的骰子滚动。
-func StartDice(bot *tgbotapi.BotAPI, chatID int64, issueNumber string) {
-	stopDice(chatID)
-
-	chatLock := getChatLock(chatID)
-	chatLock.Lock()
-	defer chatLock.Unlock()
-
-	stopFlags[chatID] = make(chan struct{})
-	go func(stopCh <-chan struct{}) {
-
-		chatDiceConfig, err := model.GetByChatId(db, chatID)
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			log.Printf("聊天ID %v 未找到配置", chatID)
-			return
-		} else if err != nil {
-			log.Printf("聊天ID %v 查找配置异常 %s", chatID, err.Error())
-			return
-		} else {
-			ticker := time.NewTicker(time.Duration(chatDiceConfig.LotteryDrawCycle) * time.Minute)
-			defer ticker.Stop()
-
-			for {
-				select {
-				case <-ticker.C:
-					nextIssueNumber := handleDiceRoll(bot, chatID, issueNumber)
-					issueNumber = nextIssueNumber
-				case <-stopCh:
-					log.Printf("已关闭任务：%v", chatID)
-					return
-				}
-			}
-		}
-
-	}(stopFlags[chatID])
-}
-
 // handleDiceRoll 处理骰子滚动过程。
 func handleDiceRoll(bot *tgbotapi.BotAPI, chatID int64, issueNumber string) (nextIssueNumber string) {
 
+	// 期号流转到开奖中，不再接受下注与撤销
+	err := model.TransitionRound(db, chatID, issueNumber, model.RoundStatusRolling)
+	if err != nil {
+		log.Printf("第%s期流转到开奖中异常: %s", issueNumber, err.Error())
+		return
+	}
+
 	redisKey := fmt.Sprintf(RedisCurrentIssueKey, chatID)
 	// 删除当前期号和对话ID
-	err := redisDB.Del(redisDB.Context(), redisKey).Err()
+	err = redisDB.Del(redisDB.Context(), redisKey).Err()
 	if err != nil {
 		log.Println("删除当前期号和对话ID异常:", err)
 		return
@@ -781,213 +1000,116 @@ func handleDiceRoll(bot *tgbotapi.BotAPI, chatID int64, issueNumber string) (nex
 
 	currentTime := time.Now().Format("2006-01-02 15:04:05")
 
-	diceValues, err := rollDice(bot, chatID, 3)
+	round, err := model.GetRound(db, chatID, issueNumber)
 	if err != nil {
-		delConfigByBlocked(err, chatID)
+		log.Printf("第%s期查询期号异常: %s", issueNumber, err.Error())
+		voidFailedDraw(bot, chatID, issueNumber)
 		return
 	}
-	count := sumDiceValues(diceValues)
-	singleOrDouble, bigOrSmall := determineResult(count)
 
-	time.Sleep(3 * time.Second)
-	triplet := 0
-	if diceValues[0] == diceValues[1] && diceValues[1] == diceValues[2] {
-		triplet = 1
+	// 公平开奖未设置封盘时间时在开奖前公布客户端种子，封盘时已公布的沿用
+	if round.SeedHash != "" {
+		if err := fixFairClientSeed(bot, round); err != nil {
+			log.Printf("第%s期生成客户端种子异常: %s", issueNumber, err.Error())
+			voidFailedDraw(bot, chatID, issueNumber)
+			return
+		}
 	}
-	message := formatMessage(diceValues[0], diceValues[1], diceValues[2], count, singleOrDouble, bigOrSmall, triplet, issueNumber)
-
-	insertLotteryRecord(chatID, issueNumber, diceValues[0], diceValues[1], diceValues[2], count, singleOrDouble, bigOrSmall, triplet, currentTime)
-
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("开奖历史", "betting_history"),
-		),
-	)
 
-	msg := tgbotapi.NewMessage(chatID, message)
-	msg.ReplyMarkup = keyboard
-	_, err = sendMessage(bot, &msg)
+	// 按期号的开奖方式获取点数
+	diceSource, err := roundDiceSource(bot, round)
 	if err != nil {
-		delConfigByBlocked(err, chatID)
+		log.Printf("第%s期获取开奖方式异常: %s", issueNumber, err.Error())
+		voidFailedDraw(bot, chatID, issueNumber)
 		return
 	}
-
-	//issueNumberInt, _ := strconv.Atoi(issueNumber)
-	nextIssueNumber = time.Now().Format("20060102150405")
-	var chatDiceConfig model.ChatDiceConfig
-	db.Where("enable = ? AND chat_id = ?", 1, chatID).First(&chatDiceConfig)
-	lotteryDrawTipMsgConfig := tgbotapi.NewMessage(chatID, fmt.Sprintf("第%s期 %d分钟后开奖", nextIssueNumber, chatDiceConfig.LotteryDrawCycle))
-	_, err = sendMessage(bot, &lotteryDrawTipMsgConfig)
+	// 由期号的游戏投掷并计算开奖结果
+	diceGame := game.Of(round.Game)
+	lotteryRecord, err := diceGame.Roll(func(emoji string, n int) ([]int, error) {
+		return diceSource.Roll(round, emoji, n)
+	})
 	if err != nil {
 		delConfigByBlocked(err, chatID)
+		voidFailedDraw(bot, chatID, issueNumber)
 		return
 	}
+	lotteryRecord.ChatID = chatID
+	lotteryRecord.IssueNumber = issueNumber
+	lotteryRecord.Timestamp = currentTime
 
-	// 设置新的期号和对话ID
-	err = redisDB.Set(redisDB.Context(), redisKey, nextIssueNumber, 0).Err()
-	if err != nil {
-		log.Println("存储新期号和对话ID异常:", err)
-	}
-
-	// 遍历下注记录，计算竞猜结果
-	go func() {
-		// 获取所有参与竞猜的用户下注记录
-		betRecords, err := model.GetBetRecordsByChatIDAndIssue(db, chatID, issueNumber)
-		if err != nil {
-			log.Println("获取用户下注记录异常:", err)
-			return
-		}
-		// 获取当前期数开奖结果
-		var lotteryRecord model.LotteryRecord
-		db.Where("issue_number = ? AND chat_id = ?", issueNumber, chatID).First(&lotteryRecord)
-
-		for _, betRecord := range betRecords {
-			// 更新用户余额
-			updateBalance(betRecord, &lotteryRecord)
-		}
-	}()
-
-	return nextIssueNumber
-}
-
-// updateBalance 更新用户余额
-func updateBalance(betRecord *model.BetRecord, lotteryRecord *model.LotteryRecord) {
-
-	// 获取用户对应的互斥锁
-	userLock := getUserLock(betRecord.TgUserID)
-	userLock.Lock()
-	defer userLock.Unlock()
-
-	tx := db.Begin()
-
-	var user model.TgUser
-	result := tx.Where("tg_user_id = ? and chat_id = ?", betRecord.TgUserID, lotteryRecord.ChatID).First(&user)
-	if result.Error != nil {
-		log.Println("获取用户信息异常:", result.Error)
-		return
+	time.Sleep(3 * time.Second)
+	message := diceGame.FormatResult(lotteryRecord)
+	if revealer, ok := diceSource.(diceRevealer); ok {
+		message += "\n" + revealer.Reveal(round)
 	}
 
-	if betRecord.BetType == lotteryRecord.SingleDouble ||
-		betRecord.BetType == lotteryRecord.BigSmall {
-		user.Balance += betRecord.BetAmount * 2
-		betResultType := 1
-		betRecord.BetResultType = &betResultType
-	} else if betRecord.BetType == "豹子" && lotteryRecord.Triplet == 1 {
-		user.Balance += betRecord.BetAmount * 10
-		betResultType := 1
-		betRecord.BetResultType = &betResultType
+	// 汇总本期下注
+	if betRecords, err := model.GetBetRecordsByChatIDAndIssue(db, chatID, issueNumber); err != nil {
+		log.Println("获取用户下注记录异常:", err)
 	} else {
-		betResultType := 0
-		betRecord.BetResultType = &betResultType
+		message += "\n\n" + formatBetSummary(betRecords)
 	}
 
-	result = tx.Save(&user)
-	if result.Error != nil {
-		log.Println("更新用户余额异常:", result.Error)
-		tx.Rollback()
-		return
-	}
-
-	// 更新下注记录表
-	betRecord.SettleStatus = 1
-	betRecord.UpdateTime = time.Now().Format("2006-01-02 15:04:05")
-	result = tx.Save(&betRecord)
-	if result.Error != nil {
-		log.Println("更新下注记录异常:", result.Error)
-		tx.Rollback()
+	err = insertLotteryRecord(lotteryRecord)
+	if err != nil {
+		voidFailedDraw(bot, chatID, issueNumber)
 		return
 	}
-
-	// 提交事务
-	if err := tx.Commit().Error; err != nil {
-		// 提交事务时出现异常，回滚事务
-		tx.Rollback()
-	}
-}
-
-// rollDice 模拟多次掷骰子。
-func rollDice(bot *tgbotapi.BotAPI, chatID int64, numDice int) ([]int, error) {
-	diceValues := make([]int, numDice)
-	diceConfig := tgbotapi.NewDiceWithEmoji(chatID, "🎲")
-
-	for i := 0; i < numDice; i++ {
-		diceMsg, err := bot.Send(diceConfig)
-		if err != nil {
-			log.Println("发送骰子消息异常:", err)
-			return nil, err
+	if err := markRoundDrawn(chatID, issueNumber); err != nil {
+		log.Printf("第%s期流转到已开奖异常: %s", issueNumber, err.Error())
+		// 无法结算的开奖结果不计入开奖历史
+		if result := db.Delete(lotteryRecord); result.Error != nil {
+			log.Println("删除开奖记录异常:", result.Error)
 		}
-		diceValues[i] = diceMsg.Dice.Value
+		if errors.Is(err, model.ErrInvalidRoundTransition) {
+			// 开奖期间期号已被作废，下注已在作废时退还
+			sendChatNotice(bot, chatID, fmt.Sprintf("第%s期已作废，本次开奖结果无效", issueNumber))
+		} else {
+			voidFailedDraw(bot, chatID, issueNumber)
+		}
+		return
 	}
 
-	return diceValues, nil
-}
-
-// sumDiceValues 计算骰子值的总和。
-func sumDiceValues(diceValues []int) int {
-	sum := 0
-	for _, value := range diceValues {
-		sum += value
-	}
-	return sum
-}
+	// 遍历下注记录，计算竞猜结果
+	go settleIssue(bot, chatID, issueNumber)
 
-// determineResult 根据骰子值的总和确定结果（单/双，大/小）。
-func determineResult(count int) (string, string) {
-	var singleOrDouble string
-	var bigOrSmall string
+	// 新的期号由调度器存储并提示开奖时间
+	nextIssueNumber = time.Now().Format("20060102150405")
 
-	if count <= 10 {
-		bigOrSmall = "小"
-	} else {
-		bigOrSmall = "大"
-	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("开奖历史", "betting_history"),
+		),
+	)
 
-	if count%2 == 1 {
-		singleOrDouble = "单"
-	} else {
-		singleOrDouble = "双"
+	msg := tgbotapi.NewMessage(chatID, message)
+	msg.ReplyMarkup = keyboard
+	_, err = sendMessage(bot, &msg)
+	if err != nil {
+		delConfigByBlocked(err, chatID)
 	}
 
-	return singleOrDouble, bigOrSmall
+	return nextIssueNumber
 }
 
-// formatMessage 格式化开奖结果消息。
-func formatMessage(valueA int, valueB int, valueC int, count int, singleOrDouble, bigOrSmall string, triplet int, issueNumber string) string {
-	tripletStr := ""
-	if triplet == 1 {
-		tripletStr = "【豹子】"
-	}
-	return fmt.Sprintf(""+
-		"点数: %d %d %d %s\n"+
-		"总点数: %d \n"+
-		"[单/双]: %s \n"+
-		"[大/小]: %s \n"+
-		"期号: %s ",
-		valueA, valueB, valueC, tripletStr,
-		count,
-		singleOrDouble,
-		bigOrSmall,
-		issueNumber,
-	)
+// markRoundDrawn 将期号流转到已开奖，数据库异常时重试，期号状态已被其他操作变更(如作废)时不再重试。
+func markRoundDrawn(chatID int64, issueNumber string) error {
+	var err error
+	for i := 0; i < drawnTransitionRetries; i++ {
+		err = model.TransitionRound(db, chatID, issueNumber, model.RoundStatusDrawn)
+		if err == nil || errors.Is(err, model.ErrInvalidRoundTransition) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+	return err
 }
 
 // insertLotteryRecord 将开奖记录插入数据库。
-func insertLotteryRecord(chatID int64, issueNumber string, valueA, valueB, valueC, total int, singleOrDouble string, bigOrSmall string, triplet int, currentTime string) {
-	record := model.LotteryRecord{
-		ChatID:       chatID,
-		IssueNumber:  issueNumber,
-		ValueA:       valueA,
-		ValueB:       valueB,
-		ValueC:       valueC,
-		Total:        total,
-		SingleDouble: singleOrDouble,
-		BigSmall:     bigOrSmall,
-		Triplet:      triplet,
-		Timestamp:    currentTime,
-	}
-
-	result := db.Create(&record)
+func insertLotteryRecord(record *model.LotteryRecord) error {
+	result := db.Create(record)
 	if result.Error != nil {
 		log.Println("插入开奖记录异常:", result.Error)
 	}
+	return result.Error
 }
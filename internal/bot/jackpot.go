@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+)
+
+const (
+	// maxJackpotPercent 下注计入奖池比例的最大值
+	maxJackpotPercent = 10
+)
+
+// jackpotAmount 计算一笔下注计入奖池的积分，向下取整。
+func jackpotAmount(betAmount int, percent int) int {
+	return betAmount * percent / 100
+}
+
+// settleJackpot 在事务中将奖池派给本期中奖的豹子下注，按下注金额比例分配，向下取整的余数留在奖池。
+// 其他期号未结算下注计入的积分在退还时需扣回，不参与本期派彩。派彩和结算后的奖池余额记录在开奖记录中。
+func settleJackpot(tx *gorm.DB, lotteryRecord *model.LotteryRecord, betRecords []*model.BetRecord, payouts []*model.LedgerEntry) ([]*model.LedgerEntry, error) {
+	balance, err := model.LockJackpotBalance(tx, lotteryRecord.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 中奖且参与奖池派彩的下注
+	diceGame := game.Of(lotteryRecord.Game)
+	won := make(map[uint]bool, len(payouts))
+	for _, payout := range payouts {
+		won[payout.BetRecordID] = true
+	}
+	var winners []*model.BetRecord
+	totalStake := 0
+	for _, betRecord := range betRecords {
+		if won[betRecord.ID] && diceGame.JackpotBet(betRecord.BetType) {
+			winners = append(winners, betRecord)
+			totalStake += betRecord.BetAmount
+		}
+	}
+
+	var entries []*model.LedgerEntry
+	paid := 0
+	if len(winners) > 0 {
+		pending, err := model.SumPendingJackpot(tx, lotteryRecord.ChatID, lotteryRecord.IssueNumber)
+		if err != nil {
+			return nil, err
+		}
+		if payable := balance - pending; payable > 0 {
+			for _, winner := range winners {
+				share := payable * winner.BetAmount / totalStake
+				if share <= 0 {
+					continue
+				}
+				entries = append(entries, &model.LedgerEntry{
+					TgUserID:    winner.TgUserID,
+					Type:        model.LedgerTypeJackpot,
+					Amount:      share,
+					IssueNumber: lotteryRecord.IssueNumber,
+					BetRecordID: winner.ID,
+					Remark:      winner.BetType,
+				})
+				paid += share
+			}
+		}
+	}
+	if err := creditBalances(tx, lotteryRecord.ChatID, entries); err != nil {
+		return nil, err
+	}
+	if err := model.AddJackpot(tx, lotteryRecord.ChatID, -paid); err != nil {
+		return nil, err
+	}
+
+	lotteryRecord.JackpotPayout = paid
+	lotteryRecord.Jackpot = balance - paid
+	result := tx.Model(lotteryRecord).Updates(map[string]interface{}{
+		"jackpot_payout": lotteryRecord.JackpotPayout,
+		"jackpot":        lotteryRecord.Jackpot,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return entries, nil
+}
+
+// chatJackpot 获取对话的奖池余额，查询失败时为 0。
+func chatJackpot(chatID int64) int {
+	balance, err := model.GetJackpotBalance(db, chatID)
+	if err != nil {
+		log.Println("查询奖池异常:", err)
+		return 0
+	}
+	return balance
+}
+
+// handleSetJackpotCommand 处理 "setjackpot" 命令，设置下注计入奖池的比例，示例: /setjackpot 2
+func handleSetJackpotCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	percent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(args), "%"))
+	if err != nil || percent < 0 || percent > maxJackpotPercent {
+		msgConfig.Text = fmt.Sprintf("格式错误！奖池比例须在0至%d之间，示例: /setjackpot 2", maxJackpotPercent)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	_, err = model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可设置奖池！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update("jackpot_percent", percent)
+	if result.Error != nil {
+		log.Println("更新奖池比例异常", result.Error)
+		return
+	}
+
+	if percent == 0 {
+		msgConfig.Text = fmt.Sprintf("已停止向奖池计入下注，当前奖池%d积分保留至开出豹子", chatJackpot(chatID))
+	} else {
+		msgConfig.Text = fmt.Sprintf("每笔下注的%d%%将计入奖池，当前奖池%d积分", percent, chatJackpot(chatID))
+	}
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
@@ -0,0 +1,345 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	// ledgerPageSize /ledger 展示的分录数量
+	ledgerPageSize = 10
+)
+
+// ledgerContraAccounts 各分录类型的对方账户，上庄、下庄和玩家坐庄期号的分录由调用方指定庄金账户
+var ledgerContraAccounts = map[int]string{
+	model.LedgerTypeOpening:  model.LedgerAccountSystem,
+	model.LedgerTypeRegister: model.LedgerAccountBonus,
+	model.LedgerTypeSignIn:   model.LedgerAccountBonus,
+	model.LedgerTypePoor:     model.LedgerAccountBonus,
+	model.LedgerTypeStake:    model.LedgerAccountHouse,
+	model.LedgerTypePayout:   model.LedgerAccountHouse,
+	model.LedgerTypeRefund:   model.LedgerAccountHouse,
+	model.LedgerTypeAdjust:   model.LedgerAccountSystem,
+	model.LedgerTypeCorrect:  model.LedgerAccountSystem,
+	model.LedgerTypeDuelHold: model.LedgerAccountEscrow,
+	model.LedgerTypeDuelWin:  model.LedgerAccountEscrow,
+	model.LedgerTypeDuelBack: model.LedgerAccountEscrow,
+	model.LedgerTypeDuelFee:  model.LedgerAccountHouse,
+	model.LedgerTypeJackpot:  model.LedgerAccountJackpot,
+	model.LedgerTypeOffBook:  model.LedgerAccountSystem,
+}
+
+// ledgerContraAccount 获取分录的对方账户，未指定时按分录类型确定。
+func ledgerContraAccount(entry *model.LedgerEntry) string {
+	if entry.ContraAccount != "" {
+		return entry.ContraAccount
+	}
+	return ledgerContraAccounts[entry.Type]
+}
+
+// errBalanceInsufficient 用户余额不足
+var errBalanceInsufficient = errors.New("余额不足")
+
+// changeBalance 在事务中变更用户余额并写入账本分录，amount 为正时入账、为负时出账，返回变动后的余额。
+// entry 需填写分录类型，可选填写期号、下注记录ID、备注和对方账户。
+// 出账使用条件更新，余额不足时返回 errBalanceInsufficient；更新会锁定用户行直到事务结束，多个实例同时变更同一用户也不会超扣。
+func changeBalance(tx *gorm.DB, chatID int64, userID int64, amount int, entry model.LedgerEntry) (int, error) {
+	query := tx.Model(&model.TgUser{}).Where("tg_user_id = ? AND chat_id = ?", userID, chatID)
+	if amount < 0 {
+		query = query.Where("balance >= ?", -amount)
+	}
+	result := query.Update("balance", gorm.Expr("balance + ?", amount))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if result := tx.Model(&model.TgUser{}).Where("tg_user_id = ? AND chat_id = ?", userID, chatID).Count(&count); result.Error != nil {
+			return 0, result.Error
+		}
+		if count == 0 {
+			return 0, gorm.ErrRecordNotFound
+		}
+		return 0, errBalanceInsufficient
+	}
+
+	var user model.TgUser
+	result = tx.Where("tg_user_id = ? AND chat_id = ?", userID, chatID).First(&user)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	userAccount := model.LedgerUserAccount(userID)
+	contraAccount := ledgerContraAccount(&entry)
+	entry.ChatID = chatID
+	entry.TgUserID = userID
+	entry.Balance = user.Balance
+	entry.CreateTime = time.Now().Format("2006-01-02 15:04:05")
+	if amount >= 0 {
+		entry.DebitAccount, entry.CreditAccount, entry.Amount = contraAccount, userAccount, amount
+	} else {
+		entry.DebitAccount, entry.CreditAccount, entry.Amount = userAccount, contraAccount, -amount
+	}
+	result = tx.Create(&entry)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return user.Balance, nil
+}
+
+// recordBalance 在事务中为用户补记一条不变更余额的分录，amount 为正时记入账、为负时记出账，分录余额为用户当前余额。
+// 用于期初余额和账外变动等余额已经变动、账本缺少记录的情况，调用方须已锁定用户行。
+func recordBalance(tx *gorm.DB, user *model.TgUser, amount int, entry model.LedgerEntry) error {
+	userAccount := model.LedgerUserAccount(user.TgUserID)
+	contraAccount := ledgerContraAccount(&entry)
+	entry.ChatID = user.ChatID
+	entry.TgUserID = user.TgUserID
+	entry.Balance = user.Balance
+	entry.CreateTime = time.Now().Format("2006-01-02 15:04:05")
+	if amount >= 0 {
+		entry.DebitAccount, entry.CreditAccount, entry.Amount = contraAccount, userAccount, amount
+	} else {
+		entry.DebitAccount, entry.CreditAccount, entry.Amount = userAccount, contraAccount, -amount
+	}
+	return tx.Create(&entry).Error
+}
+
+// postSystemTransfer 在事务中记录与用户相关的系统账户之间的划转，如对决手续费由托管转入庄家，不变更用户余额。
+// entry 需填写分录类型，转入账户按分录类型确定或由 ContraAccount 指定；balance 为用户当前余额。
+func postSystemTransfer(tx *gorm.DB, chatID int64, userID int64, debitAccount string, amount int, balance int, entry model.LedgerEntry) error {
+	entry.ChatID = chatID
+	entry.TgUserID = userID
+	entry.DebitAccount = debitAccount
+	entry.CreditAccount = ledgerContraAccount(&entry)
+	entry.Amount = amount
+	entry.Balance = balance
+	entry.CreateTime = time.Now().Format("2006-01-02 15:04:05")
+	return tx.Create(&entry).Error
+}
+
+// creditBalances 在事务中批量为用户入账并写入账本分录，每个用户只更新一次余额。
+// entries 需填写用户ID、入账金额和分录类型，可选填写期号、下注记录ID、备注和对方账户。
+func creditBalances(tx *gorm.DB, chatID int64, entries []*model.LedgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// 按用户汇总入账金额，使用 CASE 表达式一次更新所有用户
+	credits := make(map[int64]int)
+	var userIDs []int64
+	for _, entry := range entries {
+		if _, ok := credits[entry.TgUserID]; !ok {
+			userIDs = append(userIDs, entry.TgUserID)
+		}
+		credits[entry.TgUserID] += entry.Amount
+	}
+	caseSQL := "balance + CASE tg_user_id"
+	var args []interface{}
+	for _, userID := range userIDs {
+		caseSQL += " WHEN ? THEN ?"
+		args = append(args, userID, credits[userID])
+	}
+	caseSQL += " ELSE 0 END"
+
+	result := tx.Model(&model.TgUser{}).
+		Where("chat_id = ? AND tg_user_id IN ?", chatID, userIDs).
+		Update("balance", gorm.Expr(caseSQL, args...))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(userIDs)) {
+		return gorm.ErrRecordNotFound
+	}
+
+	var users []*model.TgUser
+	result = tx.Where("chat_id = ? AND tg_user_id IN ?", chatID, userIDs).Find(&users)
+	if result.Error != nil {
+		return result.Error
+	}
+	// 由更新后的余额倒推每条分录入账后的余额
+	balances := make(map[int64]int, len(users))
+	for _, user := range users {
+		balances[user.TgUserID] = user.Balance - credits[user.TgUserID]
+	}
+
+	createTime := time.Now().Format("2006-01-02 15:04:05")
+	for _, entry := range entries {
+		balances[entry.TgUserID] += entry.Amount
+		entry.ChatID = chatID
+		entry.DebitAccount = ledgerContraAccount(entry)
+		entry.CreditAccount = model.LedgerUserAccount(entry.TgUserID)
+		entry.Balance = balances[entry.TgUserID]
+		entry.CreateTime = createTime
+	}
+	return tx.Create(&entries).Error
+}
+
+// openLedgerBalances 为账本启用前注册的用户补记期初余额，保证账本合计与余额一致。
+func openLedgerBalances() {
+	users, err := model.ListUsersWithoutLedger(db)
+	if err != nil {
+		log.Println("查询未建账用户异常:", err)
+		return
+	}
+
+	opened := 0
+	for _, user := range users {
+		ok, err := openLedgerBalance(user.ID)
+		if err != nil {
+			log.Printf("用户 %v 补记期初余额异常: %s", user.TgUserID, err.Error())
+		} else if ok {
+			opened++
+		}
+	}
+	if opened > 0 {
+		log.Printf("已为%d个用户补记期初余额", opened)
+	}
+}
+
+// openLedgerBalance 在事务中为还没有分录的用户补记期初余额，返回是否补记。
+// 变更余额和写入分录都会先锁定用户行，锁定后再检查分录，多个实例或对账命令同时补记也只会记一次。
+func openLedgerBalance(id int) (bool, error) {
+	opened := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user model.TgUser
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		count, err := model.CountLedgerByChatAndUser(tx, user.ChatID, user.TgUserID)
+		if err != nil || count > 0 {
+			return err
+		}
+
+		if err := recordBalance(tx, &user, user.Balance, model.LedgerEntry{Type: model.LedgerTypeOpening}); err != nil {
+			return err
+		}
+		opened = true
+		return nil
+	})
+	return opened, err
+}
+
+// formatLedgerEntry 格式化单条账本分录。
+func formatLedgerEntry(entry *model.LedgerEntry) string {
+	text := fmt.Sprintf("%s %s %+d 余额%d", entry.CreateTime, model.LedgerTypeName(entry.Type), entry.SignedAmount(), entry.Balance)
+	if userAccount := model.LedgerUserAccount(entry.TgUserID); entry.DebitAccount != userAccount && entry.CreditAccount != userAccount {
+		// 系统账户之间的划转不变更余额，展示划转方向和金额
+		text = fmt.Sprintf("%s %s %s→%s %d", entry.CreateTime, model.LedgerTypeName(entry.Type), entry.DebitAccount, entry.CreditAccount, entry.Amount)
+	}
+	if entry.IssueNumber != "" {
+		text += fmt.Sprintf(" (第%s期)", entry.IssueNumber)
+	}
+	if entry.Remark != "" {
+		text += fmt.Sprintf(" %s", entry.Remark)
+	}
+	return text
+}
+
+// handleLedgerCommand 处理 "ledger" 命令，查看最近的积分变动。
+func handleLedgerCommand(bot *tgbotapi.BotAPI, chatMember tgbotapi.ChatMember, chatID int64, messageID int) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	entries, err := model.ListLedgerByChatAndUser(db, chatID, chatMember.User.ID, ledgerPageSize)
+	if err != nil {
+		log.Println("查询积分变动异常:", err)
+		return
+	}
+
+	if len(entries) == 0 {
+		msgConfig.Text = "您还没有积分变动记录！"
+	} else {
+		msgConfig.Text = fmt.Sprintf("%s 最近%d条积分变动:\n", chatMember.User.FirstName, len(entries))
+		for _, entry := range entries {
+			msgConfig.Text += formatLedgerEntry(entry) + "\n"
+		}
+		msgConfig.Text = strings.TrimSuffix(msgConfig.Text, "\n")
+	}
+
+	sentMsg, err := sendMessage(bot, &msgConfig)
+	if err != nil {
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	go func(messageID int) {
+		time.Sleep(1 * time.Minute)
+		deleteMsg := tgbotapi.NewDeleteMessage(chatID, messageID)
+		_, err := bot.Request(deleteMsg)
+		if err != nil {
+			log.Println("删除消息异常:", err)
+		}
+	}(sentMsg.MessageID)
+}
+
+// handleAdjustCommand 处理 "adjust" 命令，管理员调整用户积分，示例: /adjust @username 500、/adjust @username -500 备注
+func handleAdjustCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	fields := strings.Fields(args)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "@") {
+		msgConfig.Text = "格式错误！示例: /adjust @username 500、/adjust @username -500 备注"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	amount, err := strconv.Atoi(fields[1])
+	if err != nil || amount == 0 {
+		msgConfig.Text = "调整金额须为非零整数！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	username := strings.TrimPrefix(fields[0], "@")
+	remark := strings.Join(fields[2:], " ")
+
+	var user model.TgUser
+	result := db.Where("username = ? AND chat_id = ?", username, chatID).First(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		msgConfig.Text = fmt.Sprintf("用户 @%s 未注册！", username)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if result.Error != nil {
+		log.Println("查询异常:", result.Error)
+		return
+	}
+
+	// 获取用户对应的互斥锁
+	userLock := getUserLock(user.TgUserID)
+	userLock.Lock()
+	defer userLock.Unlock()
+
+	var balance int
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		balance, err = changeBalance(tx, chatID, user.TgUserID, amount, model.LedgerEntry{
+			Type:   model.LedgerTypeAdjust,
+			Remark: remark,
+		})
+		return err
+	})
+	if errors.Is(err, errBalanceInsufficient) {
+		msgConfig.Text = fmt.Sprintf("用户 @%s 余额不足，无法扣除%d积分！", username, -amount)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("调整用户积分异常:", err)
+		return
+	}
+	log.Printf("聊天ID %v 管理员调整用户 %v 积分 %+d", chatID, user.TgUserID, amount)
+
+	msgConfig.Text = fmt.Sprintf("已为 @%s 调整积分%+d，当前余额%d", username, amount, balance)
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
@@ -21,12 +21,12 @@ func getUserLock(userID int64) *sync.Mutex {
 	return userLocks[userID]
 }
 
-// getUserLock 根据userID获取对应的互斥锁，如果不存在则创建一个新的锁
+// getChatLock 根据chatID获取对应的互斥锁，如果不存在则创建一个新的锁
 func getChatLock(chatId int64) *sync.Mutex {
 	chatLocksMutex.Lock()
 	defer chatLocksMutex.Unlock()
 
-	if _, ok := userLocks[chatId]; !ok {
+	if _, ok := chatLocks[chatId]; !ok {
 		chatLocks[chatId] = &sync.Mutex{}
 	}
 
@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+)
+
+// chatOddsTable 获取对话的赔率表，包含全部游戏的赔率项，对话配置中的赔率覆盖默认赔率。
+func chatOddsTable(chatDiceConfig *model.ChatDiceConfig) map[string]float64 {
+	oddsTable := game.DefaultOddsTable()
+	if chatDiceConfig == nil || chatDiceConfig.Odds == "" {
+		return oddsTable
+	}
+
+	var chatOdds map[string]float64
+	if err := json.Unmarshal([]byte(chatDiceConfig.Odds), &chatOdds); err != nil {
+		log.Printf("聊天ID %v 赔率配置解析异常 %s", chatDiceConfig.ChatID, err.Error())
+		return oddsTable
+	}
+	for key, odds := range chatOdds {
+		if _, ok := oddsTable[key]; ok {
+			oddsTable[key] = odds
+		}
+	}
+	return oddsTable
+}
+
+// betOdds 获取下注类型在赔率表中的赔率。
+func betOdds(oddsTable map[string]float64, betType string) float64 {
+	diceGame, _, ok := game.FindByBetType(betType)
+	if !ok {
+		return 0
+	}
+	return oddsTable[diceGame.OddsKey(betType)]
+}
+
+// recordOdds 获取下注记录生效的赔率，早期未记录赔率的下注按默认赔率计算。
+func recordOdds(betRecord *model.BetRecord) float64 {
+	if betRecord.Odds > 0 {
+		return betRecord.Odds
+	}
+	return betOdds(game.DefaultOddsTable(), betRecord.BetType)
+}
+
+// resolveOddsKey 将赔率项或下注类型解析为赔率项。
+func resolveOddsKey(text string) (string, bool) {
+	if _, ok := game.DefaultOddsTable()[text]; ok {
+		return text, true
+	}
+	diceGame, betType, ok := game.FindByBetType(text)
+	if !ok {
+		return "", false
+	}
+	return diceGame.OddsKey(betType), true
+}
+
+// handleOddsCommand 处理 "odds" 命令。
+func handleOddsCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int) {
+	chatDiceConfig, err := model.GetByChatId(db, chatID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	msgConfig := tgbotapi.NewMessage(chatID, chatGame(chatDiceConfig).Help(chatOddsTable(chatDiceConfig)))
+	msgConfig.ReplyToMessageID = messageID
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
+
+// handleSetOddsCommand 处理 "setodds" 命令，示例: /setodds 单 1.95
+func handleSetOddsCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		msgConfig.Text = "格式错误！示例: /setodds 单 1.95"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	oddsKey, ok := resolveOddsKey(parts[0])
+	if !ok {
+		msgConfig.Text = fmt.Sprintf("不支持的下注类型: %s", parts[0])
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	odds, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || odds <= 1 || odds > 10000 || math.Abs(math.Round(odds*100)-odds*100) > 1e-6 {
+		msgConfig.Text = "赔率须大于1且不超过10000，最多两位小数！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	chatDiceConfig, err := model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig.Text = "开启后才可设置赔率！"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+
+	chatOdds := make(map[string]float64)
+	if chatDiceConfig.Odds != "" {
+		if err := json.Unmarshal([]byte(chatDiceConfig.Odds), &chatOdds); err != nil {
+			log.Printf("聊天ID %v 赔率配置解析异常 %s", chatID, err.Error())
+		}
+	}
+	chatOdds[oddsKey] = math.Round(odds*100) / 100
+	oddsJSON, err := json.Marshal(chatOdds)
+	if err != nil {
+		log.Println("赔率配置序列化异常", err)
+		return
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update("odds", string(oddsJSON))
+	if result.Error != nil {
+		log.Println("更新赔率配置异常", result.Error)
+		return
+	}
+
+	msgConfig.Text = fmt.Sprintf("[%s]赔率已设置为%s倍，已有下注仍按原赔率结算", oddsKey, game.FormatOdds(odds))
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
@@ -0,0 +1,200 @@
+package bot
+
+import (
+	"flag"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"os"
+	"strconv"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	ReconcileInterval = "RECONCILE_INTERVAL" // 对账任务间隔，如 1h，设为 0 时关闭
+	ReconcileRepair   = "RECONCILE_REPAIR"   // 对账任务是否自动修正，true 时修正
+)
+
+const (
+	// defaultReconcileInterval 默认对账任务间隔
+	defaultReconcileInterval = time.Hour
+)
+
+// balanceMismatch 余额与账本合计不一致的用户
+type balanceMismatch struct {
+	chatID   int64
+	userID   int64
+	username string
+	balance  int // 当前余额
+	expected int // 按账本合计的余额
+	repaired bool
+}
+
+// reconcileBalances 按账本合计核对用户余额，chatID 为 0 时核对所有对话，repair 为 true 时修正不一致的余额。
+func reconcileBalances(chatID int64, repair bool) ([]*balanceMismatch, error) {
+	users, err := model.ListUsersByChatID(db, chatID)
+	if err != nil {
+		return nil, err
+	}
+	ledgerBalances, err := model.ListLedgerBalances(db, chatID)
+	if err != nil {
+		return nil, err
+	}
+	expected := make(map[[2]int64]int, len(ledgerBalances))
+	for _, balance := range ledgerBalances {
+		expected[[2]int64{balance.ChatID, balance.TgUserID}] = balance.Balance
+	}
+
+	var mismatches []*balanceMismatch
+	for _, user := range users {
+		expectedBalance := expected[[2]int64{user.ChatID, user.TgUserID}]
+		if user.Balance == expectedBalance {
+			continue
+		}
+		mismatch := &balanceMismatch{
+			chatID:   user.ChatID,
+			userID:   user.TgUserID,
+			username: user.Username,
+			balance:  user.Balance,
+			expected: expectedBalance,
+		}
+		// 查询余额与汇总账本不在同一时刻，可能与下注、结算交错，需锁定后复核
+		if err := verifyBalance(mismatch, repair); err != nil {
+			log.Printf("聊天ID %v 用户 %v 复核余额异常: %s", user.ChatID, user.TgUserID, err.Error())
+		}
+		if mismatch.balance != mismatch.expected {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return mismatches, nil
+}
+
+// verifyBalance 锁定用户行后复核余额，repair 为 true 时将余额修正为账本合计。
+// 修正先按差额补记一条账外变动分录，使账本合计与修正前的余额一致，再写入对账修正分录冲回差额，修正后账本合计仍与余额一致。
+// 余额变动均先更新用户行，锁定后读取的余额与账本处于同一时刻，也不会被其他进程修改。
+func verifyBalance(mismatch *balanceMismatch, repair bool) error {
+	userLock := getUserLock(mismatch.userID)
+	userLock.Lock()
+	defer userLock.Unlock()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user model.TgUser
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("tg_user_id = ? AND chat_id = ?", mismatch.userID, mismatch.chatID).
+			First(&user)
+		if result.Error != nil {
+			return result.Error
+		}
+		expected, err := model.GetLedgerBalance(tx, mismatch.chatID, mismatch.userID)
+		if err != nil {
+			return err
+		}
+		mismatch.balance, mismatch.expected = user.Balance, expected
+		if user.Balance == expected || !repair {
+			return nil
+		}
+
+		if err := recordBalance(tx, &user, user.Balance-expected, model.LedgerEntry{
+			Type:   model.LedgerTypeOffBook,
+			Remark: fmt.Sprintf("账本合计%d与余额%d不一致", expected, user.Balance),
+		}); err != nil {
+			return err
+		}
+		if _, err := changeBalance(tx, mismatch.chatID, mismatch.userID, expected-user.Balance, model.LedgerEntry{
+			Type:   model.LedgerTypeCorrect,
+			Remark: fmt.Sprintf("余额%d修正为%d", user.Balance, expected),
+		}); err != nil {
+			return err
+		}
+		mismatch.repaired = true
+		return nil
+	})
+}
+
+// formatReconcileReport 按对话汇总对账结果。
+func formatReconcileReport(mismatches []*balanceMismatch) string {
+	if len(mismatches) == 0 {
+		return "对账完成，所有用户余额与账本一致"
+	}
+
+	text := fmt.Sprintf("对账完成，共%d个用户余额与账本不一致:\n", len(mismatches))
+	var chatID int64
+	for i, mismatch := range mismatches {
+		if i == 0 || mismatch.chatID != chatID {
+			chatID = mismatch.chatID
+			text += fmt.Sprintf("聊天ID %v:\n", chatID)
+		}
+		text += fmt.Sprintf("  用户 %v(%s) 余额%d 账本%d 差额%+d", mismatch.userID, mismatch.username, mismatch.balance, mismatch.expected, mismatch.balance-mismatch.expected)
+		if mismatch.repaired {
+			text += " [已修正]"
+		}
+		text += "\n"
+	}
+	return text
+}
+
+// startReconcileTask 按 RECONCILE_INTERVAL 定期对账，默认每小时一次，RECONCILE_REPAIR=true 时自动修正。
+// 对账结果输出到日志，存在不一致时向相关群发送该群的对账结果。
+func startReconcileTask(bot *tgbotapi.BotAPI) {
+	interval := defaultReconcileInterval
+	if value := os.Getenv(ReconcileInterval); value != "" {
+		var err error
+		interval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Printf("对账任务间隔 %s 格式错误，使用默认间隔%s", value, formatDrawCycle(defaultReconcileInterval))
+			interval = defaultReconcileInterval
+		}
+	}
+	if interval <= 0 {
+		log.Println("对账任务已关闭")
+		return
+	}
+	repair, _ := strconv.ParseBool(os.Getenv(ReconcileRepair))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		mismatches, err := reconcileBalances(0, repair)
+		if err != nil {
+			log.Println("对账任务异常:", err)
+			continue
+		}
+		log.Print(formatReconcileReport(mismatches))
+
+		// 不一致的用户已按对话排列，逐个对话通知
+		for start := 0; start < len(mismatches); {
+			end := start + 1
+			for end < len(mismatches) && mismatches[end].chatID == mismatches[start].chatID {
+				end++
+			}
+			sendChatNotice(bot, mismatches[start].chatID, formatReconcileReport(mismatches[start:end]))
+			start = end
+		}
+	}
+}
+
+// RunReconcile 执行对账命令行子命令，示例: tg-dice-bot reconcile -chat -100123456 -repair
+// 仅核对时不写入任何数据，账本启用前注册、尚未补记期初余额的用户按账本合计为 0 报告为不一致；修正时先补记期初余额。
+func RunReconcile(args []string) {
+	flagSet := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	chatID := flagSet.Int64("chat", 0, "仅核对指定聊天ID，默认核对所有对话")
+	repair := flagSet.Bool("repair", false, "补记未建账用户的期初余额，将不一致的余额修正为账本合计，并写入账外变动和对账修正分录")
+	_ = flagSet.Parse(args)
+
+	initDB()
+	if *repair {
+		openLedgerBalances()
+	}
+
+	mismatches, err := reconcileBalances(*chatID, *repair)
+	if err != nil {
+		log.Fatal("对账失败:", err)
+	}
+	fmt.Print(formatReconcileReport(mismatches))
+	if len(mismatches) > 0 && !*repair {
+		os.Exit(1)
+	}
+}
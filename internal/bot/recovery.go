@@ -0,0 +1,129 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"tg-dice-bot/internal/model"
+)
+
+// recoveryIssue 需要恢复的期号
+type recoveryIssue struct {
+	chatID      int64
+	issueNumber string
+}
+
+// recoverUnsettledBets 启动时恢复异常中断的期号：已开奖的补发结算，未开奖的作废并退还下注。
+// 已开启对话中开盘或封盘的期号会由 initDiceTask 继续开奖，不在此处理。
+func recoverUnsettledBets(bot *tgbotapi.BotAPI) {
+	var issues []recoveryIssue
+	seen := make(map[recoveryIssue]bool)
+	addIssue := func(chatID int64, issueNumber string) {
+		issue := recoveryIssue{chatID: chatID, issueNumber: issueNumber}
+		if !seen[issue] {
+			seen[issue] = true
+			issues = append(issues, issue)
+		}
+	}
+
+	betRecords, err := model.ListUnsettledIssues(db)
+	if err != nil {
+		log.Println("查询未结算下注异常:", err)
+		return
+	}
+	for _, record := range betRecords {
+		addIssue(record.ChatID, record.IssueNumber)
+	}
+
+	rounds, err := model.ListRoundsByStatus(db, []int{model.RoundStatusRolling, model.RoundStatusDrawn, model.RoundStatusSettling})
+	if err != nil {
+		log.Println("查询未完成期号异常:", err)
+		return
+	}
+	for _, round := range rounds {
+		addIssue(round.ChatID, round.IssueNumber)
+	}
+
+	chatDiceConfigs, err := model.ListByEnable(db, 1)
+	if err != nil {
+		log.Println("查询开奖配置异常:", err)
+		return
+	}
+	enabledChats := make(map[int64]bool, len(chatDiceConfigs))
+	for _, config := range chatDiceConfigs {
+		enabledChats[config.ChatID] = true
+	}
+
+	for _, issue := range issues {
+		recoverIssue(bot, issue.chatID, issue.issueNumber, enabledChats[issue.chatID])
+	}
+}
+
+// recoverIssue 恢复单个期号。
+func recoverIssue(bot *tgbotapi.BotAPI, chatID int64, issueNumber string, enabled bool) {
+	round, err := model.GetRound(db, chatID, issueNumber)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("第%s期查询期号异常: %s", issueNumber, err.Error())
+		return
+	}
+	hasRound := err == nil
+
+	_, err = model.GetByChatIDAndIssueNumber(db, chatID, issueNumber)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("第%s期查询开奖记录异常: %s", issueNumber, err.Error())
+		return
+	}
+	drawn := err == nil
+
+	if drawn {
+		// 已开奖，补发结算
+		if hasRound && round.Status == model.RoundStatusRolling {
+			if err := model.TransitionRound(db, chatID, issueNumber, model.RoundStatusDrawn); err != nil {
+				log.Printf("第%s期流转到已开奖异常: %s", issueNumber, err.Error())
+				return
+			}
+			round.Status = model.RoundStatusDrawn
+		}
+		if hasRound && round.Status == model.RoundStatusDrawn {
+			if err := model.TransitionRound(db, chatID, issueNumber, model.RoundStatusSettling); err != nil {
+				log.Printf("第%s期流转到结算中异常: %s", issueNumber, err.Error())
+				return
+			}
+		}
+
+		settlement, err := settleIssueBets(chatID, issueNumber)
+		if err != nil {
+			log.Printf("恢复任务: 聊天ID %v 第%s期补发结算异常: %s", chatID, issueNumber, err.Error())
+			return
+		}
+		count := len(settlement.betRecords)
+		log.Printf("恢复任务: 聊天ID %v 第%s期已开奖，补发结算%d笔下注", chatID, issueNumber, count)
+		if count > 0 {
+			sendChatNotice(bot, chatID, fmt.Sprintf("系统恢复: 第%s期已开奖，补发结算%d笔下注", issueNumber, count))
+		}
+		return
+	}
+
+	// 未开奖，已开启对话中仍在进行的期号继续开奖
+	if enabled {
+		if hasRound && (round.Status == model.RoundStatusOpen || round.Status == model.RoundStatusClosed) {
+			return
+		}
+		currentIssueNumber, err := redisDB.Get(redisDB.Context(), fmt.Sprintf(RedisCurrentIssueKey, chatID)).Result()
+		if !hasRound && err == nil && currentIssueNumber == issueNumber {
+			return
+		}
+	}
+
+	count, amount, err := voidIssue(chatID, issueNumber)
+	if err != nil {
+		log.Printf("恢复任务: 聊天ID %v 第%s期作废异常: %s", chatID, issueNumber, err.Error())
+		return
+	}
+	log.Printf("恢复任务: 聊天ID %v 第%s期未开奖，已作废并退还%d笔下注共%d积分", chatID, issueNumber, count, amount)
+	if count > 0 {
+		sendChatNotice(bot, chatID, fmt.Sprintf("系统恢复: 第%s期未开奖，已作废并退还%d笔下注共%d积分", issueNumber, count, amount))
+	}
+}
@@ -0,0 +1,448 @@
+package bot
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"sync"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	// maxConcurrentDraws 同时进行开奖的对话数量上限
+	maxConcurrentDraws = 64
+	// countdownStep 开奖倒计时的刷新间隔，距开奖较远时按 countdownLongStep 刷新
+	countdownStep     = 10 * time.Second
+	countdownLongStep = time.Minute
+	// countdownLongRange 距开奖超过该时长时按 countdownLongStep 刷新
+	countdownLongRange = 5 * time.Minute
+)
+
+// scheduler 统一调度所有对话的封盘与开奖
+var scheduler *diceScheduler
+
+// scheduleEntry 单个对话当前期号的调度信息
+type scheduleEntry struct {
+	chatID      int64
+	issueNumber string
+	closeTime   time.Time // 封盘时间，已封盘或不封盘时为零值
+	tickTime    time.Time // 下次刷新开奖倒计时的时间，不再刷新时为零值
+	drawTime    time.Time // 开奖时间
+	tipMessage  int       // 开奖倒计时消息ID
+	closed      bool      // 已封盘
+	rolling     bool      // 开奖中，不在队列中
+	stopped     bool      // 已关闭
+	index       int       // 在队列中的位置
+}
+
+// nextEventTime 获取下一个需要处理的时间点。
+func (e *scheduleEntry) nextEventTime() time.Time {
+	next := e.drawTime
+	if !e.closeTime.IsZero() && e.closeTime.Before(next) {
+		next = e.closeTime
+	}
+	if !e.tickTime.IsZero() && e.tickTime.Before(next) {
+		next = e.tickTime
+	}
+	return next
+}
+
+// scheduleQueue 按下一个处理时间排序的最小堆
+type scheduleQueue []*scheduleEntry
+
+func (q scheduleQueue) Len() int { return len(q) }
+
+func (q scheduleQueue) Less(i, j int) bool {
+	return q[i].nextEventTime().Before(q[j].nextEventTime())
+}
+
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x interface{}) {
+	entry := x.(*scheduleEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*q = old[:n-1]
+	return entry
+}
+
+// diceScheduler 使用单个协程和最小堆调度所有对话，开奖时间按开奖周期对齐。
+type diceScheduler struct {
+	bot     *tgbotapi.BotAPI
+	mu      sync.Mutex
+	entries map[int64]*scheduleEntry
+	queue   scheduleQueue
+	wakeup  chan struct{}
+	workers chan struct{}
+}
+
+// newDiceScheduler 创建调度器。
+func newDiceScheduler(bot *tgbotapi.BotAPI) *diceScheduler {
+	return &diceScheduler{
+		bot:     bot,
+		entries: make(map[int64]*scheduleEntry),
+		wakeup:  make(chan struct{}, 1),
+		workers: make(chan struct{}, maxConcurrentDraws),
+	}
+}
+
+// run 调度主循环，等待最近的封盘或开奖时间到达。
+func (s *diceScheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = time.Until(s.queue[0].nextEventTime())
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+			s.runDue(time.Now())
+		case <-s.wakeup:
+		}
+	}
+}
+
+// notify 唤醒调度主循环重新计算等待时间。
+func (s *diceScheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// runDue 处理所有已到期的封盘、倒计时刷新与开奖。
+func (s *diceScheduler) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.queue) > 0 && !s.queue[0].nextEventTime().After(now) {
+		entry := s.queue[0]
+		if !entry.closeTime.IsZero() && !entry.closeTime.After(now) {
+			// 封盘
+			entry.closeTime = time.Time{}
+			entry.closed = true
+			heap.Fix(&s.queue, 0)
+			go announceBettingClosed(s.bot, entry.chatID, entry.issueNumber, entry.drawTime)
+			go refreshDrawTip(s.bot, entry.chatID, entry.tipMessage, entry.issueNumber, entry.drawTime, true)
+			continue
+		}
+		if !entry.tickTime.IsZero() && !entry.tickTime.After(now) {
+			// 刷新倒计时
+			entry.tickTime = nextCountdownTick(now, entry.drawTime)
+			heap.Fix(&s.queue, 0)
+			go refreshDrawTip(s.bot, entry.chatID, entry.tipMessage, entry.issueNumber, entry.drawTime, entry.closed)
+			continue
+		}
+
+		// 开奖
+		heap.Pop(&s.queue)
+		entry.rolling = true
+		go editDrawTip(s.bot, entry.chatID, entry.tipMessage, fmt.Sprintf("第%s期开奖中...", entry.issueNumber))
+		go s.draw(entry)
+	}
+}
+
+// draw 执行开奖，完成后安排下一期。
+func (s *diceScheduler) draw(entry *scheduleEntry) {
+	s.workers <- struct{}{}
+	nextIssueNumber := handleDiceRoll(s.bot, entry.chatID, entry.issueNumber)
+	<-s.workers
+
+	s.mu.Lock()
+	entry.rolling = false
+	stopped := entry.stopped
+	s.mu.Unlock()
+	if stopped {
+		return
+	}
+
+	if nextIssueNumber == "" {
+		nextIssueNumber = time.Now().Format("20060102150405")
+	}
+	if _, err := s.schedule(entry.chatID, nextIssueNumber, time.Time{}); err != nil {
+		log.Printf("聊天ID %v 安排下一期异常 %s", entry.chatID, err.Error())
+	}
+}
+
+// schedule 安排对话的期号，drawTime 为零值时按开奖周期对齐计算开奖时间。
+// 期号与开奖时间持久化到 Redis，重启后可按剩余时间继续。同一对话的调度串行执行，避免并发的命令重复开盘和公告。
+func (s *diceScheduler) schedule(chatID int64, issueNumber string, drawTime time.Time) (time.Time, error) {
+	chatLock := getChatLock(chatID)
+	chatLock.Lock()
+	defer chatLock.Unlock()
+
+	chatDiceConfig, err := model.GetByChatId(db, chatID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	cutoff := time.Duration(chatDiceConfig.StopBettingSeconds) * time.Second
+	if drawTime.IsZero() {
+		drawTime = alignDrawTime(time.Now(), chatDiceConfig.DrawCycle(), cutoff)
+	}
+
+	// 公平开奖在期号创建时生成种子并公布承诺
+	var seed *model.RoundSeed
+	if chatDiceConfig.DrawMode == model.DrawModeFair {
+		seed, err = newRoundSeed()
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	// 创建或更新期号，已封盘的期号保持封盘，按已公布的开奖时间开奖
+	round, err := model.OpenRound(db, chatID, issueNumber, drawTime, chatDiceConfig.DrawMode, chatDiceConfig.Game, seed)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if round.Status == model.RoundStatusClosed {
+		drawTime, err = time.ParseInLocation("2006-01-02 15:04:05", round.DrawTime, time.Local)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if drawTime.Before(time.Now()) {
+			drawTime = time.Now()
+		}
+	} else if round.Status != model.RoundStatusOpen {
+		return time.Time{}, fmt.Errorf("第%s期状态为%s，无法调度", issueNumber, model.RoundStatusName(round.Status))
+	}
+
+	// 玩家坐庄: 先下庄坐满期数的庄家，再为新开盘的期号指定庄家
+	releaseBankers(s.bot, chatID)
+	banker, err := assignRoundBanker(round)
+	if err != nil {
+		log.Printf("第%s期指定庄家异常: %s", issueNumber, err.Error())
+	}
+
+	// 存储当前期号和开奖时间
+	redisKey := fmt.Sprintf(RedisCurrentIssueKey, chatID)
+	if err := redisDB.Set(redisDB.Context(), redisKey, issueNumber, 0).Err(); err != nil {
+		return time.Time{}, err
+	}
+	setIssueDrawTime(chatID, drawTime)
+
+	entry := &scheduleEntry{
+		chatID:      chatID,
+		issueNumber: issueNumber,
+		drawTime:    drawTime,
+		tickTime:    nextCountdownTick(time.Now(), drawTime),
+	}
+	if round.Status == model.RoundStatusClosed {
+		entry.closed = true
+	} else if cutoff > 0 {
+		// 已过封盘时间时立即封盘
+		entry.closeTime = drawTime.Add(-cutoff)
+		if now := time.Now(); entry.closeTime.Before(now) {
+			entry.closeTime = now
+		}
+	}
+	entry.tipMessage = sendDrawTip(s.bot, chatID, issueNumber, drawTime, entry.closed)
+	if round.SeedHash != "" {
+		announceFairCommitment(s.bot, round)
+	}
+	if banker != nil {
+		announceRoundBanker(s.bot, round, banker)
+	}
+
+	s.mu.Lock()
+	if old, ok := s.entries[chatID]; ok {
+		old.stopped = true
+		if old.index >= 0 && !old.rolling {
+			heap.Remove(&s.queue, old.index)
+		}
+	}
+	s.entries[chatID] = entry
+	heap.Push(&s.queue, entry)
+	s.mu.Unlock()
+	s.notify()
+	return drawTime, nil
+}
+
+// updateCutoff 按新的封盘时间调整对话当前期号的封盘时间，开奖时间不变，已封盘或开奖中的期号不受影响。
+func (s *diceScheduler) updateCutoff(chatID int64, cutoff time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[chatID]
+	if !ok || entry.closed || entry.rolling || entry.index < 0 {
+		return
+	}
+	entry.closeTime = time.Time{}
+	if cutoff > 0 {
+		// 已过新的封盘时间时立即封盘
+		entry.closeTime = entry.drawTime.Add(-cutoff)
+		if now := time.Now(); entry.closeTime.Before(now) {
+			entry.closeTime = now
+		}
+	}
+	heap.Fix(&s.queue, entry.index)
+	s.notify()
+}
+
+// remove 移除对话的调度，开奖中的期号完成后不再安排下一期。
+func (s *diceScheduler) remove(chatID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[chatID]
+	if !ok {
+		return false
+	}
+	entry.stopped = true
+	if entry.index >= 0 && !entry.rolling {
+		heap.Remove(&s.queue, entry.index)
+	}
+	delete(s.entries, chatID)
+	s.notify()
+	return true
+}
+
+// drawTimeEpoch 开奖时间对齐的固定起点，整除一天的周期对齐本地整点(如每个整分钟)，
+// 不能整除一天的周期(如7分钟)跨零点连续，不在零点重新对齐。
+var drawTimeEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)
+
+// alignDrawTime 计算下一个按开奖周期对齐的开奖时间(以 drawTimeEpoch 为起点)，
+// 距离过近导致来不及下注时顺延一个周期。
+func alignDrawTime(now time.Time, cycle time.Duration, cutoff time.Duration) time.Time {
+	cycleSeconds := int64(cycle.Seconds())
+	if cycleSeconds <= 0 {
+		cycleSeconds = 60
+	}
+	elapsed := now.Unix() - drawTimeEpoch.Unix()
+	drawTime := time.Unix(drawTimeEpoch.Unix()+(elapsed/cycleSeconds+1)*cycleSeconds, 0)
+	if drawTime.Sub(now) <= cutoff+minDrawCycle {
+		drawTime = drawTime.Add(time.Duration(cycleSeconds) * time.Second)
+	}
+	return drawTime
+}
+
+// nextCountdownTick 计算下次刷新开奖倒计时的时间，刷新点为距开奖整10秒(较远时为整分钟)，开奖前最后一次为10秒。
+func nextCountdownTick(now time.Time, drawTime time.Time) time.Time {
+	remaining := drawTime.Sub(now)
+	step := countdownStep
+	if remaining > countdownLongRange {
+		step = countdownLongStep
+	}
+	marks := (remaining - 1) / step
+	if marks <= 0 {
+		return time.Time{}
+	}
+	return drawTime.Add(-marks * step)
+}
+
+// formatDrawTip 生成开奖倒计时，奖池有余额时附带奖池。
+func formatDrawTip(issueNumber string, drawTime time.Time, closed bool, jackpot int) string {
+	remaining := time.Until(drawTime).Round(time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+	text := fmt.Sprintf("第%s期 %s后开奖(%s)", issueNumber, formatDrawCycle(remaining), drawTime.Format("15:04:05"))
+	if closed {
+		text = fmt.Sprintf("第%s期已封盘 %s后开奖(%s)", issueNumber, formatDrawCycle(remaining), drawTime.Format("15:04:05"))
+	}
+	if jackpot > 0 {
+		text += fmt.Sprintf("\n🏆 奖池: %d", jackpot)
+	}
+	return text
+}
+
+// sendDrawTip 发送开奖倒计时，返回消息ID，由调度器定时编辑更新。
+func sendDrawTip(bot *tgbotapi.BotAPI, chatID int64, issueNumber string, drawTime time.Time, closed bool) int {
+	lotteryDrawTipMsgConfig := tgbotapi.NewMessage(chatID, formatDrawTip(issueNumber, drawTime, closed, chatJackpot(chatID)))
+	sentMsg, err := sendMessage(bot, &lotteryDrawTipMsgConfig)
+	if err != nil {
+		delConfigByBlocked(err, chatID)
+		return 0
+	}
+	return sentMsg.MessageID
+}
+
+// refreshDrawTip 按当前奖池刷新开奖倒计时消息，在调度主循环之外查询奖池。
+func refreshDrawTip(bot *tgbotapi.BotAPI, chatID int64, messageID int, issueNumber string, drawTime time.Time, closed bool) {
+	if messageID == 0 {
+		return
+	}
+	editDrawTip(bot, chatID, messageID, formatDrawTip(issueNumber, drawTime, closed, chatJackpot(chatID)))
+}
+
+// editDrawTip 编辑开奖倒计时消息。
+func editDrawTip(bot *tgbotapi.BotAPI, chatID int64, messageID int, text string) {
+	if messageID == 0 {
+		return
+	}
+	editMsg := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	if _, err := bot.Request(editMsg); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Println("编辑开奖倒计时异常:", err)
+	}
+}
+
+// StartDice 启动特定聊天ID的开奖调度，按开奖周期对齐计算开奖时间。
+func StartDice(bot *tgbotapi.BotAPI, chatID int64, issueNumber string) {
+	if _, err := scheduler.schedule(chatID, issueNumber, time.Time{}); err != nil {
+		log.Printf("聊天ID %v 启动开奖调度异常 %s", chatID, err.Error())
+	}
+}
+
+// resumeDice 按期号记录的开奖时间恢复未开奖的期号，已过开奖时间的立即开奖。
+func resumeDice(bot *tgbotapi.BotAPI, chatID int64, issueNumber string) {
+	var drawTime time.Time
+	round, err := model.GetRound(db, chatID, issueNumber)
+	if err == nil {
+		drawTime, _ = time.ParseInLocation("2006-01-02 15:04:05", round.DrawTime, time.Local)
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 升级前创建的期号没有记录，使用缓存中的开奖时间
+		drawTime, err = getIssueDrawTime(chatID)
+		if err != nil {
+			log.Printf("聊天ID %v 获取开奖时间异常 %s", chatID, err.Error())
+		}
+	} else {
+		log.Printf("聊天ID %v 获取期号异常 %s", chatID, err.Error())
+	}
+	if !drawTime.IsZero() && drawTime.Before(time.Now()) {
+		drawTime = time.Now()
+	}
+	if _, err := scheduler.schedule(chatID, issueNumber, drawTime); errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("聊天ID %v 未找到配置", chatID)
+	} else if err != nil {
+		log.Printf("聊天ID %v 恢复开奖调度异常 %s", chatID, err.Error())
+	}
+}
+
+// stopDice 停止特定聊天ID的开奖调度。
+func stopDice(chatID int64) {
+	if scheduler.remove(chatID) {
+		log.Printf("停止聊天ID的任务：%v", chatID)
+	} else {
+		log.Printf("没有要停止的聊天ID的任务：%v", chatID)
+	}
+}
@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlignDrawTimeAlignsToCycle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 5, 0, time.Local)
+	drawTime := alignDrawTime(now, time.Minute, 10*time.Second)
+	if want := time.Date(2024, 1, 1, 12, 1, 0, 0, time.Local); !drawTime.Equal(want) {
+		t.Fatalf("开奖时间%s，应为%s", drawTime, want)
+	}
+
+	// 距开奖不足封盘时间加最短周期时顺延一个周期
+	now = time.Date(2024, 1, 1, 12, 0, 45, 0, time.Local)
+	drawTime = alignDrawTime(now, time.Minute, 10*time.Second)
+	if want := time.Date(2024, 1, 1, 12, 2, 0, 0, time.Local); !drawTime.Equal(want) {
+		t.Fatalf("开奖时间%s，应为%s", drawTime, want)
+	}
+}
+
+func TestAlignDrawTimeContinuesAcrossMidnight(t *testing.T) {
+	// 7分钟不能整除一天，跨零点的相邻两期仍间隔一个周期
+	cycle := 7 * time.Minute
+	midnight := time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)
+	drawTime := alignDrawTime(midnight.Add(-20*time.Minute), cycle, 0)
+	for drawTime.Before(midnight.Add(20 * time.Minute)) {
+		next := alignDrawTime(drawTime, cycle, 0)
+		if next.Sub(drawTime) != cycle {
+			t.Fatalf("%s 之后的开奖时间为%s，应间隔%s", drawTime, next, cycle)
+		}
+		drawTime = next
+	}
+}
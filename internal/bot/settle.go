@@ -0,0 +1,192 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+const (
+	// settleRetries 结算事务失败后的最多尝试次数，仍失败时期号保持结算中，等待重启时恢复
+	settleRetries = 3
+	// settleRetryInterval 结算重试的间隔，按尝试次数递增
+	settleRetryInterval = 5 * time.Second
+)
+
+// issueSettlement 期号的结算结果
+type issueSettlement struct {
+	chatID        int64
+	issueNumber   string
+	lotteryRecord *model.LotteryRecord
+	betRecords    []*model.BetRecord // 本次结算的下注记录
+	payouts       map[uint]int       // 下注记录ID对应的派彩
+	jackpots      map[uint]int       // 下注记录ID对应的奖池派彩
+	banker        *model.Banker      // 玩家坐庄时结算后的庄家，机器人坐庄时为 nil
+}
+
+// settleIssue 结算期号的全部下注并发送结算公告，期号须处于已开奖状态。
+func settleIssue(bot *tgbotapi.BotAPI, chatID int64, issueNumber string) {
+	if err := model.TransitionRound(db, chatID, issueNumber, model.RoundStatusSettling); err != nil {
+		log.Printf("第%s期流转到结算中异常: %s", issueNumber, err.Error())
+		return
+	}
+	var settlement *issueSettlement
+	var err error
+	for i := 1; i <= settleRetries; i++ {
+		settlement, err = settleIssueBets(chatID, issueNumber)
+		if err == nil {
+			break
+		}
+		log.Printf("第%s期第%d次结算异常: %s", issueNumber, i, err.Error())
+		if i < settleRetries {
+			time.Sleep(time.Duration(i) * settleRetryInterval)
+		}
+	}
+	if err != nil {
+		sendChatNotice(bot, chatID, fmt.Sprintf("第%s期结算失败，下注暂未派彩，将在机器人重启后自动结算，请联系管理员", issueNumber))
+		return
+	}
+	announceSettlement(bot, settlement)
+	if settlement.banker != nil {
+		releaseBankers(bot, chatID)
+	}
+}
+
+// settleIssueBets 在一个事务中结算期号中未结算的下注并将期号流转到已结算，返回本次结算结果。
+// 先在内存中计算全部输赢，再批量更新下注记录和用户余额，失败时整体回滚，由 settleIssue 重试，仍失败时期号保持结算中等待重启时恢复。
+// 已结算的期号和下注不会重复结算，重复执行没有副作用。
+func settleIssueBets(chatID int64, issueNumber string) (*issueSettlement, error) {
+	// 获取当前期数开奖结果
+	lotteryRecord, err := model.GetByChatIDAndIssueNumber(db, chatID, issueNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	settlement := &issueSettlement{
+		chatID:        chatID,
+		issueNumber:   issueNumber,
+		lotteryRecord: lotteryRecord,
+		payouts:       make(map[uint]int),
+		jackpots:      make(map[uint]int),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 锁定期号，同一期号的结算串行执行
+		var round model.LotteryRound
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("chat_id = ? AND issue_number = ?", chatID, issueNumber).
+			First(&round)
+		if result.Error == nil && round.Status == model.RoundStatusSettled {
+			return nil
+		} else if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return result.Error
+		}
+
+		// 锁定未结算的下注，避免与撤销下注并发
+		betRecords, err := model.ListUnsettledByChatIDAndIssue(tx.Clauses(clause.Locking{Strength: "UPDATE"}), chatID, issueNumber)
+		if err != nil {
+			return err
+		}
+
+		// 按开奖记录的游戏计算输赢和派奖
+		diceGame := game.Of(lotteryRecord.Game)
+		var winIDs, loseIDs []uint
+		var payouts []*model.LedgerEntry
+		for _, betRecord := range betRecords {
+			payout := diceGame.Settle(betRecord.BetType, betRecord.BetAmount, recordOdds(betRecord), lotteryRecord)
+			if payout == 0 {
+				loseIDs = append(loseIDs, betRecord.ID)
+				continue
+			}
+			winIDs = append(winIDs, betRecord.ID)
+			payouts = append(payouts, &model.LedgerEntry{
+				TgUserID:      betRecord.TgUserID,
+				Type:          model.LedgerTypePayout,
+				Amount:        payout,
+				IssueNumber:   issueNumber,
+				BetRecordID:   betRecord.ID,
+				Remark:        betRecord.BetType,
+				ContraAccount: roundContraAccount(&round),
+			})
+		}
+
+		// 批量更新下注记录
+		updateTime := time.Now().Format("2006-01-02 15:04:05")
+		winCount, err := model.SettleBetRecords(tx, winIDs, 1, updateTime)
+		if err != nil {
+			return err
+		}
+		loseCount, err := model.SettleBetRecords(tx, loseIDs, 0, updateTime)
+		if err != nil {
+			return err
+		}
+		if winCount+loseCount != int64(len(betRecords)) {
+			return fmt.Errorf("第%s期下注记录状态已变更", issueNumber)
+		}
+
+		// 批量派奖
+		if err := creditBalances(tx, chatID, payouts); err != nil {
+			return err
+		}
+
+		// 开出豹子时派发奖池
+		jackpots, err := settleJackpot(tx, lotteryRecord, betRecords, payouts)
+		if err != nil {
+			return err
+		}
+
+		// 玩家坐庄时由庄金承担本期输赢
+		if round.BankerID != 0 {
+			banker, err := settleBanker(tx, round.BankerID, betRecords, payouts)
+			if err != nil {
+				return err
+			}
+			settlement.banker = banker
+		}
+
+		if err := model.TransitionRound(tx, chatID, issueNumber, model.RoundStatusSettled); err != nil && !errors.Is(err, model.ErrInvalidRoundTransition) {
+			return err
+		}
+		settlement.betRecords = betRecords
+		for _, payout := range payouts {
+			settlement.payouts[payout.BetRecordID] = payout.Amount
+		}
+		for _, jackpot := range jackpots {
+			settlement.jackpots[jackpot.BetRecordID] = jackpot.Amount
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return settlement, nil
+}
+
+// settleBanker 在事务中将期号的下注合计减去计入奖池的积分和派彩后计入庄家的剩余庄金，返回结算后的庄家。
+func settleBanker(tx *gorm.DB, bankerID uint, betRecords []*model.BetRecord, payouts []*model.LedgerEntry) (*model.Banker, error) {
+	banker, err := model.LockBanker(tx, bankerID)
+	if err != nil {
+		return nil, err
+	}
+	profit := 0
+	for _, betRecord := range betRecords {
+		profit += betRecord.BetAmount - betRecord.JackpotAmount
+	}
+	for _, payout := range payouts {
+		profit -= payout.Amount
+	}
+	banker.Bankroll += profit
+	result := tx.Model(banker).Updates(map[string]interface{}{
+		"bankroll":    banker.Bankroll,
+		"update_time": time.Now().Format("2006-01-02 15:04:05"),
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return banker, nil
+}
@@ -0,0 +1,275 @@
+package bot
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"tg-dice-bot/internal/database"
+	"tg-dice-bot/internal/game"
+	"tg-dice-bot/internal/model"
+)
+
+// openTestDB 连接 MYSQL_DSN 指定的测试数据库并替换全局数据库连接，未配置时跳过测试
+func openTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv(database.DBConnectionString)
+	if dsn == "" {
+		t.Skipf("未配置 %s，跳过数据库测试", database.DBConnectionString)
+	}
+	testDB, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("连接数据库失败: %v", err)
+	}
+	err = testDB.AutoMigrate(&model.LotteryRecord{}, &model.TgUser{}, &model.BetRecord{}, &model.LotteryRound{},
+		&model.LedgerEntry{}, &model.Banker{}, &model.Jackpot{})
+	if err != nil {
+		t.Fatalf("自动迁移表结构失败: %v", err)
+	}
+
+	// 测试结束后恢复全局数据库连接
+	previous := db
+	db = testDB
+	t.Cleanup(func() {
+		db = previous
+	})
+}
+
+func TestSettleIssueBetsWithSeededRoll(t *testing.T) {
+	openTestDB(t)
+
+	chatID := -time.Now().UnixNano()
+	issueNumber := "2024010100001"
+	t.Cleanup(func() {
+		for _, table := range []interface{}{&model.LotteryRecord{}, &model.TgUser{}, &model.BetRecord{}, &model.LotteryRound{}, &model.LedgerEntry{}} {
+			db.Where("chat_id = ?", chatID).Delete(table)
+		}
+	})
+
+	currentTime := time.Now().Format("2006-01-02 15:04:05")
+	round := &model.LotteryRound{
+		ChatID:      chatID,
+		IssueNumber: issueNumber,
+		Status:      model.RoundStatusDrawn,
+		DrawTime:    currentTime,
+		Game:        model.GameDice,
+		UpdateTime:  currentTime,
+		CreateTime:  currentTime,
+	}
+	if err := db.Create(round).Error; err != nil {
+		t.Fatal(err)
+	}
+	record := seededRoll(t, 7, round)
+	record.ChatID = chatID
+	record.IssueNumber = issueNumber
+	record.Timestamp = currentTime
+	if err := db.Create(record).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 两位玩家分别下注单和双，下注时已扣除余额
+	bets := map[int64]string{1: "单", 2: "双"}
+	for userID, betType := range bets {
+		if err := db.Create(&model.TgUser{TgUserID: userID, ChatID: chatID, Username: betType, Balance: 900}).Error; err != nil {
+			t.Fatal(err)
+		}
+		betRecord := &model.BetRecord{
+			TgUserID:     userID,
+			ChatID:       chatID,
+			IssueNumber:  issueNumber,
+			BetType:      betType,
+			BetAmount:    100,
+			Odds:         2,
+			SettleStatus: model.SettleStatusUnsettled,
+			UpdateTime:   currentTime,
+			CreateTime:   currentTime,
+		}
+		if err := db.Create(betRecord).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	settlement, err := settleIssueBets(chatID, issueNumber)
+	if err != nil {
+		t.Fatalf("结算失败: %v", err)
+	}
+	if len(settlement.betRecords) != 2 || len(settlement.payouts) != 1 {
+		t.Fatalf("结算下注%d笔 派彩%d笔，应为2笔和1笔", len(settlement.betRecords), len(settlement.payouts))
+	}
+
+	winner := int64(1)
+	if record.SingleDouble != "单" {
+		winner = 2
+	}
+	for userID := range bets {
+		var user model.TgUser
+		if err := db.Where("chat_id = ? AND tg_user_id = ?", chatID, userID).First(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		want := 900
+		if userID == winner {
+			want = 1100
+		}
+		if user.Balance != want {
+			t.Fatalf("开奖结果 %s 用户%d余额%d，应为%d", game.Of(model.GameDice).FormatBrief(record), userID, user.Balance, want)
+		}
+	}
+
+	settled, err := model.GetRound(db, chatID, issueNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settled.Status != model.RoundStatusSettled {
+		t.Fatalf("期号状态%d，应为已结算", settled.Status)
+	}
+
+	// 重复结算不再派彩
+	settlement, err = settleIssueBets(chatID, issueNumber)
+	if err != nil {
+		t.Fatalf("重复结算失败: %v", err)
+	}
+	if len(settlement.payouts) != 0 {
+		t.Fatalf("重复结算派彩%d笔", len(settlement.payouts))
+	}
+}
+
+func TestFormatSettlementSummarySubtractsJackpotFromBanker(t *testing.T) {
+	settlement := &issueSettlement{
+		issueNumber:   "2024010100001",
+		lotteryRecord: &model.LotteryRecord{JackpotPayout: 1004},
+		betRecords: []*model.BetRecord{
+			{ID: 1, TgUserID: 1, BetType: "豹子", BetAmount: 100, JackpotAmount: 2},
+			{ID: 2, TgUserID: 2, BetType: "大", BetAmount: 100, JackpotAmount: 2},
+		},
+	}
+	players := []*playerResult{
+		{userID: 1, username: "a", stake: 100, payout: 1000, jackpot: 1004},
+		{userID: 2, username: "b", stake: 100},
+	}
+	text := formatSettlementSummary(settlement, players, 10)
+	if !strings.Contains(text, "派彩1000 奖池1004") || !strings.Contains(text, "本期开出奖池1004，剩余奖池0") {
+		t.Fatalf("结算公告未注明奖池派彩:\n%s", text)
+	}
+	// 庄家盈亏 = 下注合计 - 计入奖池 - 派彩，奖池派彩不由庄家承担
+	if !strings.Contains(text, "合计下注200 派彩1000 计入奖池4 庄家盈亏-804") {
+		t.Fatalf("结算公告的庄家盈亏有误:\n%s", text)
+	}
+}
+
+func TestSettleIssueBetsPaysJackpotAndBanker(t *testing.T) {
+	openTestDB(t)
+
+	chatID := -time.Now().UnixNano()
+	issueNumber := "2024010100002"
+	t.Cleanup(func() {
+		for _, table := range []interface{}{&model.LotteryRecord{}, &model.TgUser{}, &model.BetRecord{}, &model.LotteryRound{},
+			&model.LedgerEntry{}, &model.Banker{}, &model.Jackpot{}} {
+			db.Where("chat_id = ?", chatID).Delete(table)
+		}
+	})
+
+	currentTime := time.Now().Format("2006-01-02 15:04:05")
+	banker := &model.Banker{ChatID: chatID, TgUserID: 3, Bankroll: 5000, InitialBankroll: 5000, MaxIssues: 10,
+		Status: model.BankerStatusActive, UpdateTime: currentTime, CreateTime: currentTime}
+	if err := db.Create(banker).Error; err != nil {
+		t.Fatal(err)
+	}
+	round := &model.LotteryRound{
+		ChatID:      chatID,
+		IssueNumber: issueNumber,
+		Status:      model.RoundStatusDrawn,
+		DrawTime:    currentTime,
+		Game:        model.GameDice,
+		BankerID:    banker.ID,
+		UpdateTime:  currentTime,
+		CreateTime:  currentTime,
+	}
+	if err := db.Create(round).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 开出豹子3
+	record := game.Of(model.GameDice).Evaluate([]int{3, 3, 3})
+	record.ChatID = chatID
+	record.IssueNumber = issueNumber
+	record.Timestamp = currentTime
+	if err := db.Create(record).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 奖池原有1000，本期两笔下注各计入2
+	if err := model.AddJackpot(db, chatID, 1004); err != nil {
+		t.Fatal(err)
+	}
+	bets := map[int64]string{1: "豹子", 2: "大"}
+	for userID, betType := range bets {
+		if err := db.Create(&model.TgUser{TgUserID: userID, ChatID: chatID, Username: betType, Balance: 900}).Error; err != nil {
+			t.Fatal(err)
+		}
+		betRecord := &model.BetRecord{
+			TgUserID:      userID,
+			ChatID:        chatID,
+			IssueNumber:   issueNumber,
+			BetType:       betType,
+			BetAmount:     100,
+			Odds:          10,
+			JackpotAmount: 2,
+			SettleStatus:  model.SettleStatusUnsettled,
+			UpdateTime:    currentTime,
+			CreateTime:    currentTime,
+		}
+		if betType == "大" {
+			betRecord.Odds = 2
+		}
+		if err := db.Create(betRecord).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	settlement, err := settleIssueBets(chatID, issueNumber)
+	if err != nil {
+		t.Fatalf("结算失败: %v", err)
+	}
+
+	// 豹子派彩1000，唯一中奖的豹子下注独得奖池1004
+	wants := map[int64]int{1: 900 + 1000 + 1004, 2: 900}
+	for userID, want := range wants {
+		var user model.TgUser
+		if err := db.Where("chat_id = ? AND tg_user_id = ?", chatID, userID).First(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		if user.Balance != want {
+			t.Fatalf("用户%d余额%d，应为%d", userID, user.Balance, want)
+		}
+		// 派彩和奖池派彩均记入账本，账本合计与余额一致
+		ledgerBalance, err := model.GetLedgerBalance(db, chatID, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ledgerBalance != want-900 {
+			t.Fatalf("用户%d账本合计%d，应为%d", userID, ledgerBalance, want-900)
+		}
+	}
+	if jackpot, err := model.GetJackpotBalance(db, chatID); err != nil || jackpot != 0 {
+		t.Fatalf("奖池余额%d，应为0: %v", jackpot, err)
+	}
+	if settlement.lotteryRecord.JackpotPayout != 1004 || settlement.lotteryRecord.Jackpot != 0 {
+		t.Fatalf("开奖记录奖池派彩%d 奖池%d，应为1004和0", settlement.lotteryRecord.JackpotPayout, settlement.lotteryRecord.Jackpot)
+	}
+
+	// 庄家承担派彩和计入奖池的积分: 5000 + 200 - 4 - 1000
+	if settlement.banker == nil || settlement.banker.Bankroll != 4196 {
+		t.Fatalf("庄家结算后剩余庄金有误: %+v", settlement.banker)
+	}
+	players, err := summarizePlayers(settlement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := formatSettlementSummary(settlement, players, 10)
+	if !strings.Contains(text, "庄家盈亏-804") || !strings.Contains(text, "剩余庄金4196") {
+		t.Fatalf("结算公告的庄家盈亏有误:\n%s", text)
+	}
+}
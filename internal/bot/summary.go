@@ -0,0 +1,245 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/model"
+)
+
+const (
+	// maxSummaryWinnerLimit 结算公告中奖名单上限的最大值
+	maxSummaryWinnerLimit = 50
+)
+
+// playerResult 玩家在一期中的输赢汇总
+type playerResult struct {
+	userID   int64
+	username string
+	stake    int // 下注合计
+	payout   int // 派彩合计
+	jackpot  int // 奖池派彩合计
+	balance  int // 结算后余额
+}
+
+// summarizePlayers 按玩家汇总结算结果，按派彩从高到低排序。
+func summarizePlayers(settlement *issueSettlement) ([]*playerResult, error) {
+	results := make(map[int64]*playerResult)
+	var userIDs []int64
+	for _, record := range settlement.betRecords {
+		result, ok := results[record.TgUserID]
+		if !ok {
+			result = &playerResult{userID: record.TgUserID}
+			results[record.TgUserID] = result
+			userIDs = append(userIDs, record.TgUserID)
+		}
+		result.stake += record.BetAmount
+		result.payout += settlement.payouts[record.ID]
+		result.jackpot += settlement.jackpots[record.ID]
+	}
+
+	var users []*model.TgUser
+	dbResult := db.Where("chat_id = ? AND tg_user_id IN ?", settlement.chatID, userIDs).Find(&users)
+	if dbResult.Error != nil {
+		return nil, dbResult.Error
+	}
+	for _, user := range users {
+		results[user.TgUserID].username = user.Username
+		results[user.TgUserID].balance = user.Balance
+	}
+
+	players := make([]*playerResult, 0, len(userIDs))
+	for _, userID := range userIDs {
+		players = append(players, results[userID])
+	}
+	sort.SliceStable(players, func(i, j int) bool {
+		return players[i].payout > players[j].payout
+	})
+	return players, nil
+}
+
+// mentionUser 生成提及用户的 HTML 链接。
+func mentionUser(userID int64, username string) string {
+	name := username
+	if name == "" {
+		name = fmt.Sprintf("用户%d", userID)
+	}
+	return fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, userID, html.EscapeString(name))
+}
+
+// formatSettlementSummary 生成结算公告(HTML)，列出中奖名单、各下注类型合计和庄家盈亏，玩家坐庄时注明庄家和剩余庄金，开出奖池时注明奖池派彩。
+func formatSettlementSummary(settlement *issueSettlement, players []*playerResult, winnerLimit int) string {
+	text := fmt.Sprintf("第%s期结算完成\n", settlement.issueNumber)
+
+	totalStake, totalPayout := 0, 0
+	var winners []*playerResult
+	for _, player := range players {
+		totalStake += player.stake
+		totalPayout += player.payout
+		if player.payout > 0 {
+			winners = append(winners, player)
+		}
+	}
+
+	if len(winners) == 0 {
+		text += "本期无人中奖\n"
+	} else if winnerLimit > 0 {
+		text += fmt.Sprintf("中奖名单(%d人):\n", len(winners))
+		for i, winner := range winners {
+			if i >= winnerLimit {
+				text += fmt.Sprintf("...另有%d人中奖\n", len(winners)-winnerLimit)
+				break
+			}
+			text += fmt.Sprintf("%s 下注%d 派彩%d", mentionUser(winner.userID, winner.username), winner.stake, winner.payout)
+			if winner.jackpot > 0 {
+				text += fmt.Sprintf(" 奖池%d", winner.jackpot)
+			}
+			text += "\n"
+		}
+	} else {
+		text += fmt.Sprintf("本期%d人中奖\n", len(winners))
+	}
+
+	text += html.EscapeString(formatBetSummary(settlement.betRecords)) + "\n"
+	// 下注计入奖池的积分由庄家承担，庄家盈亏与结算时计入剩余庄金的积分一致
+	totalJackpot := 0
+	for _, betRecord := range settlement.betRecords {
+		totalJackpot += betRecord.JackpotAmount
+	}
+	text += fmt.Sprintf("合计下注%d 派彩%d", totalStake, totalPayout)
+	if totalJackpot > 0 {
+		text += fmt.Sprintf(" 计入奖池%d", totalJackpot)
+	}
+	text += fmt.Sprintf(" 庄家盈亏%+d", totalStake-totalJackpot-totalPayout)
+	if settlement.banker != nil {
+		text += fmt.Sprintf("\n庄家 %s 剩余庄金%d", bankerMention(settlement.banker), settlement.banker.Bankroll)
+	}
+	if jackpotPayout := settlement.lotteryRecord.JackpotPayout; jackpotPayout > 0 {
+		text += fmt.Sprintf("\n🏆 本期开出奖池%d，剩余奖池%d", jackpotPayout, settlement.lotteryRecord.Jackpot)
+	}
+	return text
+}
+
+// announceSettlement 发送结算公告，开启私聊通知时向每位参与者私聊发送个人结算结果。
+func announceSettlement(bot *tgbotapi.BotAPI, settlement *issueSettlement) {
+	if len(settlement.betRecords) == 0 {
+		return
+	}
+
+	chatDiceConfig, err := model.GetByChatId(db, settlement.chatID)
+	if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return
+	}
+	players, err := summarizePlayers(settlement)
+	if err != nil {
+		log.Printf("第%s期汇总结算结果异常: %s", settlement.issueNumber, err.Error())
+		return
+	}
+
+	msgConfig := tgbotapi.NewMessage(settlement.chatID, formatSettlementSummary(settlement, players, chatDiceConfig.SummaryWinnerLimit))
+	msgConfig.ParseMode = tgbotapi.ModeHTML
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, settlement.chatID)
+
+	if chatDiceConfig.SummaryDM != 1 {
+		return
+	}
+	for _, player := range players {
+		// 用户未私聊过机器人时无法发送，忽略即可
+		text := fmt.Sprintf("第%s期结算: 下注%d 派彩%d 盈亏%+d 当前余额%d",
+			settlement.issueNumber, player.stake, player.payout, player.payout+player.jackpot-player.stake, player.balance)
+		if player.jackpot > 0 {
+			text += fmt.Sprintf(" (含奖池派彩%d)", player.jackpot)
+		}
+		dmConfig := tgbotapi.NewMessage(player.userID, text)
+		if _, err := bot.Send(dmConfig); err != nil {
+			log.Printf("私聊用户 %v 结算结果异常: %s", player.userID, err.Error())
+		}
+	}
+}
+
+// handleSetSummaryCommand 处理 "setsummary" 命令，设置结算公告中奖名单上限，0 表示只公布中奖人数，示例: /setsummary 10
+func handleSetSummaryCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	limit, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || limit < 0 || limit > maxSummaryWinnerLimit {
+		msgConfig.Text = fmt.Sprintf("格式错误！名单上限须在0至%d之间，示例: /setsummary 10", maxSummaryWinnerLimit)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	if !updateSummaryConfig(bot, chatID, messageID, "summary_winner_limit", limit) {
+		return
+	}
+
+	if limit == 0 {
+		msgConfig.Text = "结算公告将只公布中奖人数"
+	} else {
+		msgConfig.Text = fmt.Sprintf("结算公告中奖名单上限已修改为%d人", limit)
+	}
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
+
+// handleSummaryDMCommand 处理 "summarydm" 命令，开关结算后私聊通知参与者，示例: /summarydm on
+func handleSummaryDMCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	var summaryDM int
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on":
+		summaryDM = 1
+	case "off":
+		summaryDM = 0
+	default:
+		msgConfig.Text = "格式错误！示例: /summarydm on、/summarydm off"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	if !updateSummaryConfig(bot, chatID, messageID, "summary_dm", summaryDM) {
+		return
+	}
+
+	if summaryDM == 1 {
+		msgConfig.Text = "已开启结算私聊通知，参与者需先私聊机器人才能收到"
+	} else {
+		msgConfig.Text = "已关闭结算私聊通知"
+	}
+	_, err := sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+}
+
+// updateSummaryConfig 更新结算公告配置，未开启过的对话回复提示。
+func updateSummaryConfig(bot *tgbotapi.BotAPI, chatID int64, messageID int, column string, value int) bool {
+	_, err := model.GetByChatId(db, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msgConfig := tgbotapi.NewMessage(chatID, "开启后才可设置结算公告！")
+		msgConfig.ReplyToMessageID = messageID
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return false
+	} else if err != nil {
+		log.Println("查询开奖配置异常", err)
+		return false
+	}
+
+	result := db.Model(&model.ChatDiceConfig{}).Where("chat_id = ?", chatID).Update(column, value)
+	if result.Error != nil {
+		log.Println("更新结算公告配置异常", result.Error)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"tg-dice-bot/internal/model"
+	"time"
+)
+
+// voidFailedDraw 开奖失败时作废期号并退还下注，向对话发送通知。
+func voidFailedDraw(bot *tgbotapi.BotAPI, chatID int64, issueNumber string) {
+	count, amount, err := voidIssue(chatID, issueNumber)
+	if err != nil {
+		log.Printf("聊天ID %v 第%s期开奖失败，作废异常: %s", chatID, issueNumber, err.Error())
+		return
+	}
+	log.Printf("聊天ID %v 第%s期开奖失败，已作废并退还%d笔下注共%d积分", chatID, issueNumber, count, amount)
+	sendChatNotice(bot, chatID, fmt.Sprintf("第%s期开奖失败，本期作废，已退还%d笔下注共%d积分", issueNumber, count, amount))
+}
+
+// handleVoidCommand 处理 "void" 命令，作废指定期号并退还全部下注，示例: /void 20231212120000
+func handleVoidCommand(bot *tgbotapi.BotAPI, chatID int64, messageID int, args string) {
+	msgConfig := tgbotapi.NewMessage(chatID, "")
+	msgConfig.ReplyToMessageID = messageID
+
+	redisKey := fmt.Sprintf(RedisCurrentIssueKey, chatID)
+	currentIssueNumber, err := redisDB.Get(redisDB.Context(), redisKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Println("获取值时发生异常:", err)
+		return
+	}
+
+	// 不填期号时作废当前期号
+	issueNumber := strings.TrimSpace(args)
+	if issueNumber == "" {
+		issueNumber = currentIssueNumber
+	}
+	if issueNumber == "" {
+		msgConfig.Text = "当前暂无开奖活动! 示例: /void 20231212120000"
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+
+	// 仅可作废开盘或封盘的期号，开奖中的期号由开奖流程处理
+	round, err := model.GetRound(db, chatID, issueNumber)
+	if err == nil && round.Status != model.RoundStatusOpen && round.Status != model.RoundStatusClosed {
+		msgConfig.Text = fmt.Sprintf("第%s期%s，无法作废!", issueNumber, model.RoundStatusName(round.Status))
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 升级前创建的期号没有记录，已开奖的不可作废
+		if _, err := model.GetByChatIDAndIssueNumber(db, chatID, issueNumber); err == nil {
+			msgConfig.Text = fmt.Sprintf("第%s期已开奖，无法作废!", issueNumber)
+			_, err := sendMessage(bot, &msgConfig)
+			delConfigByBlocked(err, chatID)
+			return
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("查询开奖记录异常:", err)
+			return
+		}
+	} else if err != nil {
+		log.Println("查询期号异常:", err)
+		return
+	}
+
+	count, amount, err := voidIssue(chatID, issueNumber)
+	if err != nil {
+		log.Printf("聊天ID %v 第%s期作废异常: %s", chatID, issueNumber, err.Error())
+		msgConfig.Text = fmt.Sprintf("第%s期作废失败，请稍后再试!", issueNumber)
+		_, err := sendMessage(bot, &msgConfig)
+		delConfigByBlocked(err, chatID)
+		return
+	}
+	log.Printf("聊天ID %v 第%s期已被管理员作废，退还%d笔下注共%d积分", chatID, issueNumber, count, amount)
+
+	msgConfig.Text = fmt.Sprintf("第%s期已作废，已退还%d笔下注共%d积分", issueNumber, count, amount)
+	_, err = sendMessage(bot, &msgConfig)
+	delConfigByBlocked(err, chatID)
+
+	// 作废当前期号后立即开始新的期号
+	if issueNumber == currentIssueNumber {
+		if chatDiceConfig, err := model.GetByEnableAndChatId(db, 1, chatID); err == nil {
+			StartDice(bot, chatDiceConfig.ChatID, time.Now().Format("20060102150405"))
+		}
+	}
+}
+
+// voidIssue 作废未开奖的期号，并在同一事务中退还全部未结算的下注，返回退还笔数和积分。
+func voidIssue(chatID int64, issueNumber string) (count int, amount int, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := model.TransitionRound(tx, chatID, issueNumber, model.RoundStatusVoided)
+		if errors.Is(err, model.ErrInvalidRoundTransition) {
+			// 升级前创建的期号没有记录，直接退还
+			if _, getErr := model.GetRound(tx, chatID, issueNumber); !errors.Is(getErr, gorm.ErrRecordNotFound) {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		round, err := model.GetRound(tx, chatID, issueNumber)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		betRecords, err := model.ListUnsettledByChatIDAndIssue(tx, chatID, issueNumber)
+		if err != nil {
+			return err
+		}
+		if len(betRecords) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(betRecords))
+		jackpot := 0
+		for _, record := range betRecords {
+			ids = append(ids, record.ID)
+			amount += record.BetAmount
+			jackpot += record.JackpotAmount
+		}
+
+		result := tx.Model(&model.BetRecord{}).
+			Where("id IN ? AND settle_status = ?", ids, model.SettleStatusUnsettled).
+			Updates(map[string]interface{}{
+				"settle_status": model.SettleStatusRefunded,
+				"update_time":   time.Now().Format("2006-01-02 15:04:05"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(ids)) {
+			return errors.New("下注记录状态已变更")
+		}
+
+		// 退还用户积分，每笔下注记一条分录
+		for _, record := range betRecords {
+			_, err := changeBalance(tx, chatID, record.TgUserID, record.BetAmount, model.LedgerEntry{
+				Type:          model.LedgerTypeRefund,
+				IssueNumber:   issueNumber,
+				BetRecordID:   record.ID,
+				Remark:        "期号作废",
+				ContraAccount: roundContraAccount(round),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// 扣回下注时计入奖池的积分
+		if err := model.AddJackpot(tx, chatID, -jackpot); err != nil {
+			return err
+		}
+		count = len(betRecords)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 作废的期号不再作为当前期号
+	redisKey := fmt.Sprintf(RedisCurrentIssueKey, chatID)
+	if currentIssueNumber, err := redisDB.Get(redisDB.Context(), redisKey).Result(); err == nil && currentIssueNumber == issueNumber {
+		if err := redisDB.Del(redisDB.Context(), redisKey).Err(); err != nil {
+			log.Println("删除当前期号和对话ID异常:", err)
+		}
+	}
+	return count, amount, nil
+}
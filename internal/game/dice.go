@@ -0,0 +1,262 @@
+package game
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"tg-dice-bot/internal/model"
+)
+
+// diceGame 骰子游戏(单双大小豹子)，每期投掷三颗 🎲
+var diceGame = &marketGame{
+	key:     model.GameDice,
+	name:    "骰子",
+	emoji:   "🎲",
+	throws:  3,
+	markets: diceMarkets,
+	odds: map[string]float64{
+		"单":    2,
+		"双":    2,
+		"大":    2,
+		"小":    2,
+		"大单":   3.5,
+		"大双":   4.6,
+		"小单":   4.6,
+		"小双":   3.5,
+		"豹子":   10,
+		"指定豹子": 150,
+		"对子":   2,
+		"指定对子": 11,
+		"和4":   61,
+		"和5":   31,
+		"和6":   18,
+		"和7":   13,
+		"和8":   9,
+		"和9":   7,
+		"和10":  7,
+		"和11":  7,
+		"和12":  7,
+		"和13":  9,
+		"和14":  13,
+		"和15":  18,
+		"和16":  31,
+		"和17":  61,
+		"组合":   6,
+		"三军":   2,
+	},
+	evaluate: func(values []int) *model.LotteryRecord {
+		count := SumDiceValues(values)
+		singleOrDouble, bigOrSmall := DetermineResult(count)
+		triplet := 0
+		if IsTriplet([3]int{values[0], values[1], values[2]}) {
+			triplet = 1
+		}
+		return &model.LotteryRecord{
+			ValueA:       values[0],
+			ValueB:       values[1],
+			ValueC:       values[2],
+			Total:        count,
+			SingleDouble: singleOrDouble,
+			BigSmall:     bigOrSmall,
+			Triplet:      triplet,
+		}
+	},
+	brief: func(record *model.LotteryRecord) string {
+		triplet := ""
+		if record.Triplet == 1 {
+			triplet = "【豹子】"
+		}
+		return fmt.Sprintf("%d %d %d  %d  %s  %s %s",
+			record.ValueA, record.ValueB, record.ValueC, record.Total, record.SingleDouble, record.BigSmall, triplet)
+	},
+	format: formatDiceMessage,
+}
+
+// diceMarkets 骰子游戏的下注玩法，/help 与 /myhistory 均按此顺序展示。
+var diceMarkets = []*market{
+	{
+		name:     "单双",
+		example:  "#单 20",
+		oddsKeys: []string{"单", "双"},
+		parse:    parseFixedBetType("单", "双"),
+		oddsKey:  sameOddsKey,
+		hits: func(betType string, dice [3]int) int {
+			singleOrDouble, _ := DetermineResult(SumDiceValues(dice[:]))
+			return boolHits(betType == singleOrDouble)
+		},
+	},
+	{
+		name:     "大小",
+		example:  "#大 20",
+		oddsKeys: []string{"大", "小"},
+		parse:    parseFixedBetType("大", "小"),
+		oddsKey:  sameOddsKey,
+		hits: func(betType string, dice [3]int) int {
+			_, bigOrSmall := DetermineResult(SumDiceValues(dice[:]))
+			return boolHits(betType == bigOrSmall)
+		},
+	},
+	{
+		name:     "大小单双",
+		example:  "#大单 20",
+		note:     "大小与单双同时命中，开出豹子不中",
+		oddsKeys: []string{"大单", "大双", "小单", "小双"},
+		parse:    parseFixedBetType("大单", "大双", "小单", "小双"),
+		oddsKey:  sameOddsKey,
+		hits: func(betType string, dice [3]int) int {
+			singleOrDouble, bigOrSmall := DetermineResult(SumDiceValues(dice[:]))
+			return boolHits(!IsTriplet(dice) && betType == bigOrSmall+singleOrDouble)
+		},
+	},
+	{
+		name:     "豹子",
+		example:  "#豹子 20",
+		oddsKeys: []string{"豹子"},
+		parse:    parseFixedBetType("豹子"),
+		oddsKey:  sameOddsKey,
+		hits: func(betType string, dice [3]int) int {
+			return boolHits(IsTriplet(dice))
+		},
+		jackpot: true,
+	},
+	{
+		name:     "指定豹子",
+		example:  "#豹子6 20",
+		oddsKeys: []string{"指定豹子"},
+		parse:    parseNumberBetType("豹子", 1, 6),
+		oddsKey:  fixedOddsKey("指定豹子"),
+		hits: func(betType string, dice [3]int) int {
+			point, _ := strconv.Atoi(strings.TrimPrefix(betType, "豹子"))
+			return boolHits(IsTriplet(dice) && dice[0] == point)
+		},
+		jackpot: true,
+	},
+	{
+		name:     "对子",
+		example:  "#对子 20",
+		note:     "任意两颗及以上点数相同",
+		oddsKeys: []string{"对子"},
+		parse:    parseFixedBetType("对子"),
+		oddsKey:  sameOddsKey,
+		hits: func(betType string, dice [3]int) int {
+			for point := 1; point <= 6; point++ {
+				if countPoint(dice, point) >= 2 {
+					return 1
+				}
+			}
+			return 0
+		},
+	},
+	{
+		name:     "指定对子",
+		example:  "#对子3 20",
+		oddsKeys: []string{"指定对子"},
+		parse:    parseNumberBetType("对子", 1, 6),
+		oddsKey:  fixedOddsKey("指定对子"),
+		hits: func(betType string, dice [3]int) int {
+			point, _ := strconv.Atoi(strings.TrimPrefix(betType, "对子"))
+			return boolHits(countPoint(dice, point) >= 2)
+		},
+	},
+	{
+		name:     "和值",
+		example:  "#和10 20",
+		oddsKeys: []string{"和4", "和5", "和6", "和7", "和8", "和9", "和10", "和11", "和12", "和13", "和14", "和15", "和16", "和17"},
+		parse:    parseNumberBetType("和", 4, 17),
+		oddsKey:  sameOddsKey,
+		hits: func(betType string, dice [3]int) int {
+			total, _ := strconv.Atoi(strings.TrimPrefix(betType, "和"))
+			return boolHits(SumDiceValues(dice[:]) == total)
+		},
+	},
+	{
+		name:     "组合",
+		example:  "#组合12 20",
+		note:     "两颗指定的不同点数同时出现",
+		oddsKeys: []string{"组合"},
+		parse:    parseCombinationBetType,
+		oddsKey:  fixedOddsKey("组合"),
+		hits: func(betType string, dice [3]int) int {
+			points := strings.TrimPrefix(betType, "组合")
+			pointA, pointB := int(points[0]-'0'), int(points[1]-'0')
+			return boolHits(countPoint(dice, pointA) > 0 && countPoint(dice, pointB) > 0)
+		},
+	},
+	{
+		name:     "三军",
+		example:  "#三军3 20",
+		note:     "按指定点数出现次数累计派彩",
+		oddsKeys: []string{"三军"},
+		parse:    parseNumberBetType("三军", 1, 6),
+		oddsKey:  fixedOddsKey("三军"),
+		hits: func(betType string, dice [3]int) int {
+			point, _ := strconv.Atoi(strings.TrimPrefix(betType, "三军"))
+			return countPoint(dice, point)
+		},
+	},
+}
+
+// parseCombinationBetType 解析两骰组合下注类型，如 "组合12"，点数按从小到大规范化。
+func parseCombinationBetType(betType string) (string, bool) {
+	points := strings.TrimPrefix(betType, "组合")
+	if points == betType || len(points) != 2 {
+		return "", false
+	}
+	pointA, pointB := int(points[0]-'0'), int(points[1]-'0')
+	if pointA < 1 || pointA > 6 || pointB < 1 || pointB > 6 || pointA == pointB {
+		return "", false
+	}
+	if pointA > pointB {
+		pointA, pointB = pointB, pointA
+	}
+	return fmt.Sprintf("组合%d%d", pointA, pointB), true
+}
+
+// SumDiceValues 计算骰子值的总和。
+func SumDiceValues(diceValues []int) int {
+	sum := 0
+	for _, value := range diceValues {
+		sum += value
+	}
+	return sum
+}
+
+// DetermineResult 根据骰子值的总和确定结果（单/双，大/小）。
+func DetermineResult(count int) (string, string) {
+	var singleOrDouble string
+	var bigOrSmall string
+
+	if count <= 10 {
+		bigOrSmall = "小"
+	} else {
+		bigOrSmall = "大"
+	}
+
+	if count%2 == 1 {
+		singleOrDouble = "单"
+	} else {
+		singleOrDouble = "双"
+	}
+
+	return singleOrDouble, bigOrSmall
+}
+
+// formatDiceMessage 格式化骰子开奖结果消息。
+func formatDiceMessage(record *model.LotteryRecord) string {
+	tripletStr := ""
+	if record.Triplet == 1 {
+		tripletStr = "【豹子】"
+	}
+	return fmt.Sprintf(""+
+		"点数: %d %d %d %s\n"+
+		"总点数: %d \n"+
+		"[单/双]: %s \n"+
+		"[大/小]: %s \n"+
+		"期号: %s ",
+		record.ValueA, record.ValueB, record.ValueC, tripletStr,
+		record.Total,
+		record.SingleDouble,
+		record.BigSmall,
+		record.IssueNumber,
+	)
+}
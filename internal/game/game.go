@@ -0,0 +1,148 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"tg-dice-bot/internal/model"
+)
+
+// Game 一种开奖游戏，负责下注的解析与校验、投掷、开奖结果的计算与展示以及下注结算。
+// 新游戏实现该接口并通过 Register 注册后，对话即可通过配置选择，无需修改开奖和下注流程。
+type Game interface {
+	// Key 游戏标识，对应对话配置中的游戏
+	Key() string
+	// Title 游戏的展示名称，如 🎯飞镖
+	Title() string
+	// ParseBet 解析下注类型，返回规范化后的下注类型，不属于本游戏时返回 false
+	ParseBet(betType string) (string, bool)
+	// ValidateBet 校验一笔下注能否在本游戏中进行
+	ValidateBet(betType string, betAmount int) error
+	// Roll 通过 throw 投掷并计算开奖结果
+	Roll(throw Thrower) (*model.LotteryRecord, error)
+	// Evaluate 由投掷值计算开奖结果，开奖记录中 ValueA、ValueB、ValueC 为结算使用的三个值
+	Evaluate(values []int) *model.LotteryRecord
+	// Settle 按开奖结果结算一笔下注，返回派彩金额(含本金)，0 表示未中奖
+	Settle(betType string, betAmount int, odds float64, record *model.LotteryRecord) int
+	// Outcomes 列出全部可能的开奖结果，用于按开奖结果计算玩家坐庄时庄家的最大赔付，调用方不能修改返回的开奖记录
+	Outcomes() []*model.LotteryRecord
+	// JackpotBet 下注类型中奖时是否参与奖池派彩
+	JackpotBet(betType string) bool
+	// OddsKey 获取下注类型对应的赔率项
+	OddsKey(betType string) string
+	// DefaultOdds 获取默认赔率表的副本，调用方可以修改
+	DefaultOdds() map[string]float64
+	// BetTypeIndex 获取下注类型在本游戏中的展示顺序，取值 0 至 maxMarkets-1，/help、/myhistory 和下注看板按此排列
+	BetTypeIndex(betType string) int
+	// FormatResult 格式化开奖结果公告
+	FormatResult(record *model.LotteryRecord) string
+	// FormatBrief 生成一行开奖结果，用于开奖历史和验证
+	FormatBrief(record *model.LotteryRecord) string
+	// Help 根据赔率表生成支持的下注种类说明
+	Help(oddsTable map[string]float64) string
+}
+
+// Thrower 投掷 n 次 Telegram 骰子表情，返回与 Telegram 相同取值范围的值
+type Thrower func(emoji string, n int) ([]int, error)
+
+var (
+	// ErrUnsupportedBet 下注类型不属于本游戏
+	ErrUnsupportedBet = errors.New("本期游戏不支持该下注")
+	// ErrInvalidBetAmount 下注金额无效
+	ErrInvalidBetAmount = errors.New("下注金额无效")
+)
+
+// maxMarkets 单个游戏展示顺序的上限，用于计算跨游戏的展示顺序
+const maxMarkets = 100
+
+// games 游戏注册表，/setgame 按此顺序展示，第一个为默认游戏
+var games = []Game{diceGame, dartGame, basketballGame, footballGame, bowlingGame, slotGame}
+
+// Register 注册游戏，须在机器人启动前调用，各游戏的下注类型和赔率项不能重复。
+// 游戏标识或默认赔率项与已注册的游戏重复时 panic。
+func Register(g Game) {
+	for _, registered := range games {
+		if registered.Key() == g.Key() {
+			panic(fmt.Sprintf("游戏标识 %s 重复注册", g.Key()))
+		}
+		for key := range registered.DefaultOdds() {
+			if _, ok := g.DefaultOdds()[key]; ok {
+				panic(fmt.Sprintf("游戏 %s 的赔率项 %s 与游戏 %s 重复", g.Key(), key, registered.Key()))
+			}
+		}
+	}
+	games = append(games, g)
+}
+
+// List 按注册顺序列出全部游戏。
+func List() []Game {
+	return games
+}
+
+// Default 获取默认游戏(骰子)。
+func Default() Game {
+	return games[0]
+}
+
+// Get 根据游戏标识查找游戏，标识为空时为默认游戏。
+func Get(key string) (Game, bool) {
+	if key == "" {
+		return Default(), true
+	}
+	for _, g := range games {
+		if g.Key() == key {
+			return g, true
+		}
+	}
+	return nil, false
+}
+
+// Of 根据游戏标识获取游戏，未知的游戏按默认游戏处理。
+func Of(key string) Game {
+	if g, ok := Get(key); ok {
+		return g
+	}
+	return Default()
+}
+
+// FindByBetType 查找下注类型所属的游戏，返回规范化后的下注类型。
+func FindByBetType(betType string) (Game, string, bool) {
+	for _, g := range games {
+		if normalized, ok := g.ParseBet(betType); ok {
+			return g, normalized, true
+		}
+	}
+	return nil, "", false
+}
+
+// BetTypeIndex 获取下注类型的展示顺序，按游戏注册顺序和游戏内玩法顺序排列，未知类型排在最后。
+func BetTypeIndex(betType string) int {
+	for i, g := range games {
+		if _, ok := g.ParseBet(betType); !ok {
+			continue
+		}
+		return i*maxMarkets + g.BetTypeIndex(betType)
+	}
+	return len(games) * maxMarkets
+}
+
+// DefaultOddsTable 合并全部游戏的默认赔率表。
+func DefaultOddsTable() map[string]float64 {
+	oddsTable := make(map[string]float64)
+	for _, g := range games {
+		for key, odds := range g.DefaultOdds() {
+			oddsTable[key] = odds
+		}
+	}
+	return oddsTable
+}
+
+// EmojiMaxValue 获取 Telegram 骰子表情的最大值，🏀 和 ⚽ 为 1-5，🎰 为 1-64，其余为 1-6。
+func EmojiMaxValue(emoji string) int {
+	switch emoji {
+	case "🏀", "⚽":
+		return 5
+	case "🎰":
+		return 64
+	}
+	return 6
+}
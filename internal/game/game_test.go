@@ -0,0 +1,33 @@
+package game
+
+import "testing"
+
+func TestDefaultOddsReturnsCopy(t *testing.T) {
+	odds := diceGame.DefaultOdds()
+	for key := range odds {
+		odds[key] = 0
+	}
+	for key, value := range diceGame.DefaultOdds() {
+		if value == 0 {
+			t.Fatalf("修改返回的赔率表后默认赔率项 %s 被修改", key)
+		}
+	}
+}
+
+func TestRegisterRejectsDuplicateKey(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("重复注册游戏标识未 panic")
+		}
+	}()
+	Register(diceGame)
+}
+
+func TestOutcomesEnumeratesAllThrows(t *testing.T) {
+	if n := len(diceGame.Outcomes()); n != 216 {
+		t.Fatalf("骰子开奖结果%d种，应为216种", n)
+	}
+	if n := len(slotGame.Outcomes()); n != 64 {
+		t.Fatalf("老虎机开奖结果%d种，应为64种", n)
+	}
+}
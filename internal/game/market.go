@@ -0,0 +1,263 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"tg-dice-bot/internal/model"
+)
+
+// market 描述一种下注玩法，负责解析、校验与结算。
+type market struct {
+	name     string   // 玩法名称
+	example  string   // 下注示例
+	note     string   // 玩法说明
+	oddsKeys []string // 玩法包含的赔率项
+	// parse 校验下注类型，返回规范化后的下注类型
+	parse func(betType string) (string, bool)
+	// oddsKey 返回下注类型对应的赔率项
+	oddsKey func(betType string) string
+	// hits 根据开奖记录的三个值计算命中次数，0 表示未中奖
+	hits func(betType string, values [3]int) int
+	// jackpot 中奖时参与奖池派彩
+	jackpot bool
+}
+
+// marketGame 由一组下注玩法组成的游戏，每期投掷同一种骰子表情若干次
+type marketGame struct {
+	key     string             // 游戏标识
+	name    string             // 游戏名称
+	emoji   string             // Telegram 骰子表情
+	throws  int                // 每期投掷次数
+	markets []*market          // 下注玩法，/help 与 /myhistory 均按此顺序展示
+	odds    map[string]float64 // 默认赔率表(派彩倍数含本金)
+	// evaluate 将投掷值转换为开奖记录
+	evaluate func(values []int) *model.LotteryRecord
+	// brief 生成一行开奖结果
+	brief func(record *model.LotteryRecord) string
+	// format 格式化开奖结果公告，为空时使用一行开奖结果加期号
+	format func(record *model.LotteryRecord) string
+
+	outcomesOnce sync.Once
+	outcomes     []*model.LotteryRecord // 全部可能的开奖结果，首次使用时枚举
+}
+
+func (g *marketGame) Key() string {
+	return g.key
+}
+
+func (g *marketGame) Title() string {
+	return g.emoji + g.name
+}
+
+func (g *marketGame) ParseBet(betType string) (string, bool) {
+	if m := g.findMarket(betType); m != nil {
+		return m.parse(betType)
+	}
+	return "", false
+}
+
+func (g *marketGame) ValidateBet(betType string, betAmount int) error {
+	if g.findMarket(betType) == nil {
+		return ErrUnsupportedBet
+	}
+	if betAmount <= 0 || betAmount > math.MaxInt32 {
+		return ErrInvalidBetAmount
+	}
+	return nil
+}
+
+func (g *marketGame) Roll(throw Thrower) (*model.LotteryRecord, error) {
+	values, err := throw(g.emoji, g.throws)
+	if err != nil {
+		return nil, err
+	}
+	return g.Evaluate(values), nil
+}
+
+func (g *marketGame) Evaluate(values []int) *model.LotteryRecord {
+	record := g.evaluate(values)
+	record.Game = g.key
+	return record
+}
+
+func (g *marketGame) Settle(betType string, betAmount int, odds float64, record *model.LotteryRecord) int {
+	m := g.findMarket(betType)
+	if m == nil {
+		return 0
+	}
+	return Payout(betAmount, odds, m.hits(betType, [3]int{record.ValueA, record.ValueB, record.ValueC}))
+}
+
+func (g *marketGame) Outcomes() []*model.LotteryRecord {
+	g.outcomesOnce.Do(func() {
+		// 按投掷次数枚举每次投掷的全部取值
+		maxValue := EmojiMaxValue(g.emoji)
+		values := make([]int, g.throws)
+		for i := range values {
+			values[i] = 1
+		}
+		for {
+			g.outcomes = append(g.outcomes, g.Evaluate(append([]int(nil), values...)))
+			i := len(values) - 1
+			for i >= 0 && values[i] == maxValue {
+				values[i] = 1
+				i--
+			}
+			if i < 0 {
+				break
+			}
+			values[i]++
+		}
+	})
+	return g.outcomes
+}
+
+func (g *marketGame) JackpotBet(betType string) bool {
+	m := g.findMarket(betType)
+	return m != nil && m.jackpot
+}
+
+func (g *marketGame) OddsKey(betType string) string {
+	if m := g.findMarket(betType); m != nil {
+		return m.oddsKey(betType)
+	}
+	return ""
+}
+
+func (g *marketGame) DefaultOdds() map[string]float64 {
+	odds := make(map[string]float64, len(g.odds))
+	for key, value := range g.odds {
+		odds[key] = value
+	}
+	return odds
+}
+
+func (g *marketGame) BetTypeIndex(betType string) int {
+	for i, m := range g.markets {
+		if _, ok := m.parse(betType); ok {
+			return i
+		}
+	}
+	return len(g.markets)
+}
+
+func (g *marketGame) FormatResult(record *model.LotteryRecord) string {
+	if g.format != nil {
+		return g.format(record)
+	}
+	return fmt.Sprintf("%s\n期号: %s ", g.brief(record), record.IssueNumber)
+}
+
+func (g *marketGame) FormatBrief(record *model.LotteryRecord) string {
+	return g.brief(record)
+}
+
+func (g *marketGame) Help(oddsTable map[string]float64) string {
+	text := fmt.Sprintf("当前游戏: %s\n支持下注种类(派彩倍数含本金):\n", g.Title())
+	for _, m := range g.markets {
+		var oddsTexts []string
+		if len(m.oddsKeys) == 1 {
+			oddsTexts = append(oddsTexts, FormatOdds(oddsTable[m.oddsKeys[0]])+"倍")
+		} else {
+			for _, key := range m.oddsKeys {
+				oddsTexts = append(oddsTexts, fmt.Sprintf("%s:%s倍", key, FormatOdds(oddsTable[key])))
+			}
+		}
+		text += fmt.Sprintf("%s(%s): %s", m.name, m.example, strings.Join(oddsTexts, " "))
+		if m.note != "" {
+			text += fmt.Sprintf(" [%s]", m.note)
+		}
+		text += "\n"
+	}
+	return text
+}
+
+// findMarket 查找下注类型所属的玩法。
+func (g *marketGame) findMarket(betType string) *market {
+	for _, m := range g.markets {
+		if _, ok := m.parse(betType); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+// Payout 计算派彩金额(含本金)，每次命中按赔率累计净赢，结果向下取整。
+func Payout(betAmount int, odds float64, hits int) int {
+	if hits <= 0 {
+		return 0
+	}
+	payout := float64(betAmount) * (1 + float64(hits)*(odds-1))
+	// 消除浮点误差，如 20*1.95 应为 39
+	return int(math.Floor(math.Round(payout*100) / 100))
+}
+
+// FormatOdds 格式化赔率。
+func FormatOdds(odds float64) string {
+	return strconv.FormatFloat(odds, 'f', -1, 64)
+}
+
+// sameOddsKey 以下注类型本身作为赔率项。
+func sameOddsKey(betType string) string {
+	return betType
+}
+
+// fixedOddsKey 返回固定赔率项。
+func fixedOddsKey(key string) func(string) string {
+	return func(string) string {
+		return key
+	}
+}
+
+// boolHits 将是否中奖转换为命中次数。
+func boolHits(win bool) int {
+	if win {
+		return 1
+	}
+	return 0
+}
+
+// parseFixedBetType 返回只接受固定下注类型的解析函数。
+func parseFixedBetType(betTypes ...string) func(string) (string, bool) {
+	return func(betType string) (string, bool) {
+		for _, t := range betTypes {
+			if betType == t {
+				return betType, true
+			}
+		}
+		return "", false
+	}
+}
+
+// parseNumberBetType 返回解析 "前缀+数字" 形式下注类型的解析函数。
+func parseNumberBetType(prefix string, min, max int) func(string) (string, bool) {
+	return func(betType string) (string, bool) {
+		if !strings.HasPrefix(betType, prefix) {
+			return "", false
+		}
+		number, err := strconv.Atoi(strings.TrimPrefix(betType, prefix))
+		if err != nil || number < min || number > max {
+			return "", false
+		}
+		return fmt.Sprintf("%s%d", prefix, number), true
+	}
+}
+
+// IsTriplet 判断三个值是否相同，如骰子的豹子、老虎机的三同。
+func IsTriplet(values [3]int) bool {
+	return values[0] == values[1] && values[1] == values[2]
+}
+
+// countPoint 统计指定值出现的次数。
+func countPoint(values [3]int, point int) int {
+	count := 0
+	for _, value := range values {
+		if value == point {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,190 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+	"tg-dice-bot/internal/model"
+)
+
+// dartGame 飞镖游戏，投掷值 1 为脱靶，2-3 为外环，4-5 为内环，6 为靶心
+var dartGame = &marketGame{
+	key:    model.GameDart,
+	name:   "飞镖",
+	emoji:  "🎯",
+	throws: 1,
+	markets: []*market{
+		throwMarket("靶心", "#靶心 20", "", 6),
+		throwMarket("内环", "#内环 20", "", 4, 5),
+		throwMarket("外环", "#外环 20", "", 2, 3),
+		throwMarket("脱靶", "#脱靶 20", "", 1),
+	},
+	odds:     map[string]float64{"靶心": 5.5, "内环": 2.8, "外环": 2.8, "脱靶": 5.5},
+	evaluate: singleThrowRecord,
+	brief:    singleThrowBrief("🎯", map[int]string{1: "脱靶", 2: "外环", 3: "外环", 4: "内环", 5: "内环", 6: "靶心"}),
+}
+
+// basketballGame 篮球游戏，投掷值 4-5 为投中
+var basketballGame = &marketGame{
+	key:    model.GameBasketball,
+	name:   "篮球",
+	emoji:  "🏀",
+	throws: 1,
+	markets: []*market{
+		throwMarket("投中", "#投中 20", "", 4, 5),
+		throwMarket("投丢", "#投丢 20", "", 1, 2, 3),
+	},
+	odds:     map[string]float64{"投中": 2.4, "投丢": 1.6},
+	evaluate: singleThrowRecord,
+	brief:    singleThrowBrief("🏀", map[int]string{1: "投丢", 2: "投丢", 3: "投丢", 4: "投中", 5: "投中"}),
+}
+
+// footballGame 足球游戏，投掷值 3-5 为进球
+var footballGame = &marketGame{
+	key:    model.GameFootball,
+	name:   "足球",
+	emoji:  "⚽",
+	throws: 1,
+	markets: []*market{
+		throwMarket("进球", "#进球 20", "", 3, 4, 5),
+		throwMarket("射失", "#射失 20", "", 1, 2),
+	},
+	odds:     map[string]float64{"进球": 1.6, "射失": 2.4},
+	evaluate: singleThrowRecord,
+	brief:    singleThrowBrief("⚽", map[int]string{1: "射失", 2: "射失", 3: "进球", 4: "进球", 5: "进球"}),
+}
+
+// bowlingGame 保龄球游戏，投掷值 1 为洗沟，6 为全中
+var bowlingGame = &marketGame{
+	key:    model.GameBowling,
+	name:   "保龄球",
+	emoji:  "🎳",
+	throws: 1,
+	markets: []*market{
+		throwMarket("全中", "#全中 20", "", 6),
+		throwMarket("中瓶", "#中瓶 20", "击倒部分球瓶", 2, 3, 4, 5),
+		throwMarket("洗沟", "#洗沟 20", "", 1),
+	},
+	odds:     map[string]float64{"全中": 5.5, "中瓶": 1.4, "洗沟": 5.5},
+	evaluate: singleThrowRecord,
+	brief:    singleThrowBrief("🎳", map[int]string{1: "洗沟", 2: "中瓶", 3: "中瓶", 4: "中瓶", 5: "中瓶", 6: "全中"}),
+}
+
+// slotGame 老虎机游戏，按三个转轮的图案结算，开奖记录中三个值为转轮编号(1-4)，Total 为 Telegram 原始值
+var slotGame = &marketGame{
+	key:    model.GameSlot,
+	name:   "老虎机",
+	emoji:  "🎰",
+	throws: 1,
+	markets: []*market{
+		{
+			name:     "777",
+			example:  "#777 20",
+			note:     "三个转轮均为7",
+			oddsKeys: []string{"777"},
+			parse:    parseFixedBetType("777"),
+			oddsKey:  sameOddsKey,
+			hits: func(betType string, reels [3]int) int {
+				return boolHits(IsTriplet(reels) && reels[0] == slotSeven)
+			},
+		},
+		{
+			name:     "三同",
+			example:  "#三同 20",
+			note:     "三个转轮图案相同，含777",
+			oddsKeys: []string{"三同"},
+			parse:    parseFixedBetType("三同"),
+			oddsKey:  sameOddsKey,
+			hits: func(betType string, reels [3]int) int {
+				return boolHits(IsTriplet(reels))
+			},
+		},
+		{
+			name:     "有7",
+			example:  "#有7 20",
+			note:     "至少一个转轮为7",
+			oddsKeys: []string{"有7"},
+			parse:    parseFixedBetType("有7"),
+			oddsKey:  sameOddsKey,
+			hits: func(betType string, reels [3]int) int {
+				return boolHits(countPoint(reels, slotSeven) > 0)
+			},
+		},
+	},
+	odds: map[string]float64{"777": 58, "三同": 14, "有7": 1.6},
+	evaluate: func(values []int) *model.LotteryRecord {
+		reels := SlotReels(values[0])
+		triplet := 0
+		if IsTriplet(reels) {
+			triplet = 1
+		}
+		return &model.LotteryRecord{
+			ValueA:  reels[0],
+			ValueB:  reels[1],
+			ValueC:  reels[2],
+			Total:   values[0],
+			Triplet: triplet,
+		}
+	},
+	brief: func(record *model.LotteryRecord) string {
+		text := "🎰 " + strings.Join([]string{
+			slotSymbols[record.ValueA-1], slotSymbols[record.ValueB-1], slotSymbols[record.ValueC-1],
+		}, " ")
+		if record.ValueA == slotSeven && record.Triplet == 1 {
+			text += " 【777大奖】"
+		} else if record.Triplet == 1 {
+			text += " 【三同】"
+		}
+		return text
+	},
+}
+
+// throwMarket 创建按单次投掷值结算的下注玩法，投掷值为 values 之一时中奖。
+func throwMarket(name string, example string, note string, values ...int) *market {
+	return &market{
+		name:     name,
+		example:  example,
+		note:     note,
+		oddsKeys: []string{name},
+		parse:    parseFixedBetType(name),
+		oddsKey:  sameOddsKey,
+		hits: func(betType string, throw [3]int) int {
+			for _, value := range values {
+				if throw[0] == value {
+					return 1
+				}
+			}
+			return 0
+		},
+	}
+}
+
+// singleThrowRecord 单次投掷游戏的开奖记录，ValueA 和 Total 均为投掷值。
+func singleThrowRecord(values []int) *model.LotteryRecord {
+	return &model.LotteryRecord{
+		ValueA: values[0],
+		Total:  values[0],
+	}
+}
+
+// singleThrowBrief 返回按投掷值展示结果名称的函数。
+func singleThrowBrief(emoji string, names map[int]string) func(*model.LotteryRecord) string {
+	return func(record *model.LotteryRecord) string {
+		return fmt.Sprintf("%s %d %s", emoji, record.ValueA, names[record.ValueA])
+	}
+}
+
+// slotSeven 老虎机转轮中 7 的编号
+const slotSeven = 4
+
+// slotSymbols 老虎机转轮图案，按编号 1-4 排列
+var slotSymbols = []string{"BAR", "🍇", "🍋", "7️⃣"}
+
+// SlotReels 将 Telegram 老虎机的值(1-64)解码为三个转轮的编号(1-4)，
+// 值减一后每两位对应一个转轮，64 为 777。
+func SlotReels(value int) [3]int {
+	var reels [3]int
+	for i := range reels {
+		reels[i] = ((value-1)>>(2*i))&3 + 1
+	}
+	return reels
+}
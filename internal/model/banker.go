@@ -0,0 +1,74 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 庄家状态
+const (
+	BankerStatusWaiting  = 0 // 排队等待坐庄，庄金已锁定
+	BankerStatusActive   = 1 // 坐庄中
+	BankerStatusReleased = 2 // 已下庄，剩余庄金已退还
+)
+
+// Banker 玩家坐庄，上庄时锁定庄金，坐庄期号的下注由庄金承担输赢，坐满期数后下庄并退还剩余庄金
+type Banker struct {
+	ID              uint   `gorm:"primarykey"`
+	ChatID          int64  `json:"chat_id" gorm:"type:bigint(20);not null;index"`
+	TgUserID        int64  `json:"tg_user_id" gorm:"type:bigint(20);not null"`    // 庄家用户ID
+	Bankroll        int    `json:"bankroll" gorm:"type:int(11);not null"`         // 剩余庄金，不含未结算期号的下注
+	InitialBankroll int    `json:"initial_bankroll" gorm:"type:int(11);not null"` // 上庄时锁定的庄金
+	MaxIssues       int    `json:"max_issues" gorm:"type:int(11);not null"`       // 坐庄期数
+	Issues          int    `json:"issues" gorm:"type:int(11);not null;default:0"` // 已坐庄期数
+	Status          int    `json:"status" gorm:"type:int(11);not null;index"`     // 庄家状态
+	UpdateTime      string `json:"update_time" gorm:"type:varchar(255);not null"`
+	CreateTime      string `json:"create_time" gorm:"type:varchar(255);not null"`
+}
+
+// GetBanker 根据ID获取庄家
+func GetBanker(db *gorm.DB, id uint) (*Banker, error) {
+	var banker *Banker
+	result := db.First(&banker, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return banker, nil
+}
+
+// LockBanker 在事务中锁定并获取庄家，同一庄家的上庄、下注、结算和下庄串行执行
+func LockBanker(tx *gorm.DB, id uint) (*Banker, error) {
+	return GetBanker(tx.Clauses(clause.Locking{Strength: "UPDATE"}), id)
+}
+
+// ListBankersByChatAndStatus 按上庄顺序获取对话中处于指定状态的庄家
+func ListBankersByChatAndStatus(db *gorm.DB, chatID int64, statuses []int) ([]*Banker, error) {
+	var bankers []*Banker
+	result := db.Where("chat_id = ? AND status IN ?", chatID, statuses).Order("id").Find(&bankers)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return bankers, nil
+}
+
+// ListUnfinishedRoundsByBanker 获取庄家坐庄的期号中未结算且未作废的期号
+func ListUnfinishedRoundsByBanker(db *gorm.DB, bankerID uint) ([]*LotteryRound, error) {
+	var rounds []*LotteryRound
+	result := db.Where("banker_id = ? AND status NOT IN ?", bankerID, []int{RoundStatusSettled, RoundStatusVoided}).Find(&rounds)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return rounds, nil
+}
+
+// CountUnfinishedRoundsByBanker 统计庄家坐庄的期号中未结算且未作废的数量
+func CountUnfinishedRoundsByBanker(db *gorm.DB, bankerID uint) (int64, error) {
+	var count int64
+	result := db.Model(&LotteryRound{}).
+		Where("banker_id = ? AND status NOT IN ?", bankerID, []int{RoundStatusSettled, RoundStatusVoided}).
+		Count(&count)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return count, nil
+}
@@ -2,23 +2,33 @@ package model
 
 import "gorm.io/gorm"
 
+// 下注记录结算状态
+const (
+	SettleStatusUnsettled = 0 // 未结算
+	SettleStatusSettled   = 1 // 已结算
+	SettleStatusCancelled = 2 // 已撤销
+	SettleStatusRefunded  = 3 // 已退还(期号作废)
+)
+
 type BetRecord struct {
-	ID            uint   `gorm:"primarykey"`
-	TgUserID      int64  `json:"tg_user_id" gorm:"type:bigint(20);not null"` // 用户ID
-	ChatID        int64  `json:"chat_id" gorm:"type:bigint(20);not null;index"`
-	IssueNumber   string `json:"issue_number" gorm:"type:varchar(64);not null"`
-	BetType       string `json:"bet_type" gorm:"type:varchar(64);not null"`        // 下注类型
-	BetAmount     int    `json:"bet_amount" gorm:"type:int(11);not null"`          // 下注金额
-	SettleStatus  int    `json:"settle_status" gorm:"type:int(11);not null"`       // 结算状态
-	BetResultType *int   `json:"bet_result_type" gorm:"type:int(11);default:null"` // 下注结果输赢
-	UpdateTime    string `json:"update_time" gorm:"type:varchar(255);not null"`
-	CreateTime    string `json:"create_time" gorm:"type:varchar(255);not null"`
-}
-
-// GetBetRecordsByChatIDAndIssue 根据对话ID和期号获取用户下注记录
+	ID            uint    `gorm:"primarykey"`
+	TgUserID      int64   `json:"tg_user_id" gorm:"type:bigint(20);not null"` // 用户ID
+	ChatID        int64   `json:"chat_id" gorm:"type:bigint(20);not null;index"`
+	IssueNumber   string  `json:"issue_number" gorm:"type:varchar(64);not null"`
+	BetType       string  `json:"bet_type" gorm:"type:varchar(64);not null"`             // 下注类型
+	BetAmount     int     `json:"bet_amount" gorm:"type:int(11);not null"`               // 下注金额
+	Odds          float64 `json:"odds" gorm:"type:decimal(10,2);not null;default:0"`     // 下注时生效的赔率
+	JackpotAmount int     `json:"jackpot_amount" gorm:"type:int(11);not null;default:0"` // 下注时计入奖池的积分
+	SettleStatus  int     `json:"settle_status" gorm:"type:int(11);not null"`            // 结算状态
+	BetResultType *int    `json:"bet_result_type" gorm:"type:int(11);default:null"`      // 下注结果输赢
+	UpdateTime    string  `json:"update_time" gorm:"type:varchar(255);not null"`
+	CreateTime    string  `json:"create_time" gorm:"type:varchar(255);not null"`
+}
+
+// GetBetRecordsByChatIDAndIssue 根据对话ID和期号获取用户下注记录(不含已撤销和已退还)
 func GetBetRecordsByChatIDAndIssue(db *gorm.DB, chatID int64, issueNumber string) ([]*BetRecord, error) {
 	var betRecords []*BetRecord
-	result := db.Where("chat_id = ? AND issue_number = ?", chatID, issueNumber).Find(&betRecords)
+	result := db.Where("chat_id = ? AND issue_number = ? AND settle_status NOT IN ?", chatID, issueNumber, []int{SettleStatusCancelled, SettleStatusRefunded}).Find(&betRecords)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -28,7 +38,7 @@ func GetBetRecordsByChatIDAndIssue(db *gorm.DB, chatID int64, issueNumber string
 // ListBySettleStatus
 func ListBySettleStatus(db *gorm.DB, betRecord *BetRecord) ([]*BetRecord, error) {
 	var betRecords []*BetRecord
-	result := db.Where("tg_user_id = ? AND chat_id = ? AND settle_status = ?", betRecord.TgUserID, betRecord.ChatID, 0).Find(&betRecords)
+	result := db.Where("tg_user_id = ? AND chat_id = ? AND settle_status = ?", betRecord.TgUserID, betRecord.ChatID, SettleStatusUnsettled).Find(&betRecords)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -43,3 +53,51 @@ func ListByChatAndUser(db *gorm.DB, betRecord *BetRecord) ([]*BetRecord, error)
 	}
 	return betRecords, nil
 }
+
+// ListUnsettledByChatAndUserAndIssue 获取用户在指定期号中未结算的下注记录
+func ListUnsettledByChatAndUserAndIssue(db *gorm.DB, chatID int64, userID int64, issueNumber string) ([]*BetRecord, error) {
+	var betRecords []*BetRecord
+	result := db.Where("chat_id = ? AND tg_user_id = ? AND issue_number = ? AND settle_status = ?", chatID, userID, issueNumber, SettleStatusUnsettled).Find(&betRecords)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return betRecords, nil
+}
+
+// ListUnsettledIssues 获取存在未结算下注的对话和期号
+func ListUnsettledIssues(db *gorm.DB) ([]*BetRecord, error) {
+	var betRecords []*BetRecord
+	result := db.Model(&BetRecord{}).Distinct("chat_id", "issue_number").Where("settle_status = ?", SettleStatusUnsettled).Find(&betRecords)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return betRecords, nil
+}
+
+// ListUnsettledByChatIDAndIssue 获取期号中全部未结算的下注记录
+func ListUnsettledByChatIDAndIssue(db *gorm.DB, chatID int64, issueNumber string) ([]*BetRecord, error) {
+	var betRecords []*BetRecord
+	result := db.Where("chat_id = ? AND issue_number = ? AND settle_status = ?", chatID, issueNumber, SettleStatusUnsettled).Find(&betRecords)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return betRecords, nil
+}
+
+// SettleBetRecords 批量将未结算的下注记录标记为已结算，返回更新的记录数
+func SettleBetRecords(db *gorm.DB, ids []uint, betResultType int, updateTime string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := db.Model(&BetRecord{}).
+		Where("id IN ? AND settle_status = ?", ids, SettleStatusUnsettled).
+		Updates(map[string]interface{}{
+			"settle_status":   SettleStatusSettled,
+			"bet_result_type": betResultType,
+			"update_time":     updateTime,
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
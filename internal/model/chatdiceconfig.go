@@ -1,12 +1,51 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// 开奖方式
+const (
+	DrawModeTelegram = "telegram" // Telegram 骰子
+	DrawModeFair     = "fair"     // 可验证公平(承诺-公布)
+	DrawModeCrypto   = "crypto"   // 本地加密随机数
+	DrawModeSeeded   = "seeded"   // 已移除的固定种子开奖方式，仍保存该值的对话和期号按默认开奖方式开奖
+)
+
+// 游戏
+const (
+	GameDice       = "dice"       // 🎲 骰子
+	GameDart       = "dart"       // 🎯 飞镖
+	GameBasketball = "basketball" // 🏀 篮球
+	GameFootball   = "football"   // ⚽ 足球
+	GameBowling    = "bowling"    // 🎳 保龄球
+	GameSlot       = "slot"       // 🎰 老虎机
+)
 
 type ChatDiceConfig struct {
-	ID               int   `gorm:"primaryKey"`
-	ChatID           int64 `json:"chat_id" gorm:"type:bigint(20);not null;index"`
-	LotteryDrawCycle int   `json:"lottery_draw_cycle" gorm:"type:int(11);not null"` // 开奖周期(分钟)
-	Enable           int   `json:"enable" gorm:"type:int(11);not null"`             // 开启状态
+	ID                     int    `gorm:"primaryKey"`
+	ChatID                 int64  `json:"chat_id" gorm:"type:bigint(20);not null;index"`
+	LotteryDrawCycle       int    `json:"lottery_draw_cycle" gorm:"type:int(11);not null"`                  // 开奖周期(分钟)
+	LotteryDrawCycleSecond int    `json:"lottery_draw_cycle_second" gorm:"type:int(11);not null;default:0"` // 开奖周期(秒)，为0时按分钟周期
+	StopBettingSeconds     int    `json:"stop_betting_seconds" gorm:"type:int(11);not null;default:10"`     // 开奖前封盘秒数
+	Enable                 int    `json:"enable" gorm:"type:int(11);not null"`                              // 开启状态
+	Odds                   string `json:"odds" gorm:"type:text"`                                            // 赔率配置(JSON)
+	SummaryWinnerLimit     int    `json:"summary_winner_limit" gorm:"type:int(11);not null;default:10"`     // 结算公告中奖名单上限
+	SummaryDM              int    `json:"summary_dm" gorm:"type:int(11);not null;default:0"`                // 结算后私聊通知参与者
+	DrawMode               string `json:"draw_mode" gorm:"type:varchar(32);not null;default:'telegram'"`    // 开奖方式
+	Game                   string `json:"game" gorm:"type:varchar(32);not null;default:'dice'"`             // 游戏
+	DuelFeePercent         int    `json:"duel_fee_percent" gorm:"type:int(11);not null;default:0"`          // 对决手续费比例(%)
+	BankerIssues           int    `json:"banker_issues" gorm:"type:int(11);not null;default:10"`            // 玩家坐庄的期数
+	JackpotPercent         int    `json:"jackpot_percent" gorm:"type:int(11);not null;default:0"`           // 下注计入奖池的比例(%)
+}
+
+// DrawCycle 获取开奖周期，优先使用秒级周期
+func (c *ChatDiceConfig) DrawCycle() time.Duration {
+	if c.LotteryDrawCycleSecond > 0 {
+		return time.Duration(c.LotteryDrawCycleSecond) * time.Second
+	}
+	return time.Duration(c.LotteryDrawCycle) * time.Minute
 }
 
 func ListByEnable(db *gorm.DB, enable int) ([]*ChatDiceConfig, error) {
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// 对决状态
+const (
+	DuelStatusPending  = 0 // 等待应战，发起方押金已托管
+	DuelStatusAccepted = 1 // 已应战，双方押金已托管，等待掷骰
+	DuelStatusFinished = 2 // 已结束，奖池已派给胜方
+	DuelStatusRefunded = 3 // 已退还(超时未应战或掷骰失败)
+)
+
+// ErrInvalidDuelTransition 对决状态不允许该流转
+var ErrInvalidDuelTransition = errors.New("对决状态流转无效")
+
+// Duel 玩家之间的骰子对决，双方押注相同积分，点数大者赢得奖池
+type Duel struct {
+	ID              uint   `gorm:"primarykey"`
+	ChatID          int64  `json:"chat_id" gorm:"type:bigint(20);not null;index"`
+	ChallengerID    int64  `json:"challenger_id" gorm:"type:bigint(20);not null"`           // 发起方用户ID
+	OpponentID      int64  `json:"opponent_id" gorm:"type:bigint(20);not null"`             // 应战方用户ID
+	Amount          int    `json:"amount" gorm:"type:int(11);not null"`                     // 每方押注积分
+	FeePercent      int    `json:"fee_percent" gorm:"type:int(11);not null;default:0"`      // 发起时的手续费比例(%)
+	Status          int    `json:"status" gorm:"type:int(11);not null;index"`               // 对决状态
+	ChallengerValue int    `json:"challenger_value" gorm:"type:int(11);not null;default:0"` // 发起方点数
+	OpponentValue   int    `json:"opponent_value" gorm:"type:int(11);not null;default:0"`   // 应战方点数
+	WinnerID        int64  `json:"winner_id" gorm:"type:bigint(20);not null;default:0"`     // 胜方用户ID
+	MessageID       int    `json:"message_id" gorm:"type:int(11);not null;default:0"`       // 对决消息ID
+	ExpireTime      string `json:"expire_time" gorm:"type:varchar(255);not null"`           // 应战截止时间
+	UpdateTime      string `json:"update_time" gorm:"type:varchar(255);not null"`
+	CreateTime      string `json:"create_time" gorm:"type:varchar(255);not null"`
+}
+
+// GetDuel 根据ID获取对决
+func GetDuel(db *gorm.DB, id uint) (*Duel, error) {
+	var duel *Duel
+	result := db.First(&duel, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return duel, nil
+}
+
+// ListDuelsByStatus 获取所有处于指定状态的对决
+func ListDuelsByStatus(db *gorm.DB, statuses []int) ([]*Duel, error) {
+	var duels []*Duel
+	result := db.Where("status IN ?", statuses).Order("id").Find(&duels)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return duels, nil
+}
+
+// TransitionDuel 将对决从 from 状态流转到 to 状态并更新 updates 中的字段，
+// 条件更新保证并发或多实例下同一流转只会成功一次
+func TransitionDuel(db *gorm.DB, id uint, from int, to int, updates map[string]interface{}) error {
+	values := map[string]interface{}{
+		"status":      to,
+		"update_time": time.Now().Format("2006-01-02 15:04:05"),
+	}
+	for column, value := range updates {
+		values[column] = value
+	}
+	result := db.Model(&Duel{}).Where("id = ? AND status = ?", id, from).Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidDuelTransition
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// Jackpot 对话的累积奖池，每笔下注按比例计入，开出豹子时按下注金额派给豹子的中奖者
+type Jackpot struct {
+	ID         uint   `gorm:"primarykey"`
+	ChatID     int64  `json:"chat_id" gorm:"type:bigint(20);not null;uniqueIndex"`
+	Balance    int    `json:"balance" gorm:"type:int(11);not null;default:0"` // 奖池余额，含未结算下注计入的积分
+	UpdateTime string `json:"update_time" gorm:"type:varchar(255);not null"`
+	CreateTime string `json:"create_time" gorm:"type:varchar(255);not null"`
+}
+
+// GetJackpotBalance 获取对话的奖池余额，没有奖池时为 0
+func GetJackpotBalance(db *gorm.DB, chatID int64) (int, error) {
+	var jackpot Jackpot
+	result := db.Where("chat_id = ?", chatID).First(&jackpot)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return 0, nil
+	} else if result.Error != nil {
+		return 0, result.Error
+	}
+	return jackpot.Balance, nil
+}
+
+// LockJackpotBalance 在事务中锁定并获取对话的奖池余额，没有奖池时为 0
+func LockJackpotBalance(tx *gorm.DB, chatID int64) (int, error) {
+	return GetJackpotBalance(tx.Clauses(clause.Locking{Strength: "UPDATE"}), chatID)
+}
+
+// AddJackpot 变更对话的奖池余额，amount 为负时从奖池扣除，对话还没有奖池时创建
+func AddJackpot(db *gorm.DB, chatID int64, amount int) error {
+	if amount == 0 {
+		return nil
+	}
+	currentTime := time.Now().Format("2006-01-02 15:04:05")
+	result := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "chat_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"balance":     gorm.Expr("balance + ?", amount),
+			"update_time": currentTime,
+		}),
+	}).Create(&Jackpot{
+		ChatID:     chatID,
+		Balance:    amount,
+		UpdateTime: currentTime,
+		CreateTime: currentTime,
+	})
+	return result.Error
+}
+
+// SumPendingJackpot 合计对话中其他期号未结算下注计入奖池的积分，这部分在下注退还时需从奖池扣回
+func SumPendingJackpot(db *gorm.DB, chatID int64, excludeIssueNumber string) (int, error) {
+	var sum int
+	result := db.Model(&BetRecord{}).
+		Select("COALESCE(SUM(jackpot_amount), 0)").
+		Where("chat_id = ? AND issue_number <> ? AND settle_status = ?", chatID, excludeIssueNumber, SettleStatusUnsettled).
+		Scan(&sum)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return sum, nil
+}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+// 账本分录类型
+const (
+	LedgerTypeOpening  = 0  // 期初余额(账本启用前的余额)
+	LedgerTypeRegister = 1  // 注册赠送
+	LedgerTypeSignIn   = 2  // 签到奖励
+	LedgerTypePoor     = 3  // 领取低保
+	LedgerTypeStake    = 4  // 下注
+	LedgerTypePayout   = 5  // 派奖
+	LedgerTypeRefund   = 6  // 退还(撤销下注或期号作废)
+	LedgerTypeAdjust   = 7  // 管理员调整
+	LedgerTypeCorrect  = 8  // 对账修正，将余额修正为账本合计
+	LedgerTypeDuelHold = 9  // 对决押金托管
+	LedgerTypeDuelWin  = 10 // 对决赢得奖池
+	LedgerTypeDuelBack = 11 // 对决押金退还
+	LedgerTypeDuelFee  = 12 // 对决手续费，由托管转入庄家，不变更用户余额
+	LedgerTypeBankLock = 13 // 上庄锁定庄金
+	LedgerTypeBankBack = 14 // 下庄退还剩余庄金
+	LedgerTypeJackpot  = 15 // 奖池派彩
+	LedgerTypeOffBook  = 16 // 账外变动，对账时补记未写入账本的余额差额，不变更余额，随后由对账修正冲回
+)
+
+// 系统账户
+const (
+	LedgerAccountHouse   = "house"   // 庄家，机器人坐庄时下注、派奖和退还的对方账户
+	LedgerAccountBonus   = "bonus"   // 赠送，注册、签到和低保的对方账户
+	LedgerAccountSystem  = "system"  // 系统，期初余额、管理员调整、账外变动和对账修正的对方账户
+	LedgerAccountEscrow  = "escrow"  // 托管，对决押金、派奖和退还的对方账户
+	LedgerAccountJackpot = "jackpot" // 累积奖池，奖池派彩的对方账户
+)
+
+// LedgerBankAccount 获取玩家庄家的庄金账户名，上庄、下庄以及坐庄期号的下注、派奖和退还以此为对方账户
+func LedgerBankAccount(bankerID uint) string {
+	return fmt.Sprintf("bank:%d", bankerID)
+}
+
+// ledgerTypeNames 账本分录类型名称
+var ledgerTypeNames = map[int]string{
+	LedgerTypeOpening:  "期初余额",
+	LedgerTypeRegister: "注册赠送",
+	LedgerTypeSignIn:   "签到奖励",
+	LedgerTypePoor:     "领取低保",
+	LedgerTypeStake:    "下注",
+	LedgerTypePayout:   "派奖",
+	LedgerTypeRefund:   "退还",
+	LedgerTypeAdjust:   "管理员调整",
+	LedgerTypeCorrect:  "对账修正",
+	LedgerTypeDuelHold: "对决押金",
+	LedgerTypeDuelWin:  "对决赢得",
+	LedgerTypeDuelBack: "对决退还",
+	LedgerTypeDuelFee:  "对决手续费",
+	LedgerTypeBankLock: "上庄",
+	LedgerTypeBankBack: "下庄",
+	LedgerTypeJackpot:  "奖池派彩",
+	LedgerTypeOffBook:  "账外变动",
+}
+
+// LedgerEntry 账本分录，用户积分流水：每笔用户余额变动记一条，记录转出(借方)和转入(贷方)账户，其中一方为用户账户，金额恒为正。
+// 只记录用户账户的变动，庄家、托管、庄金和奖池等系统账户之间的划转(如下注计入奖池、庄金结算)不记分录，系统账户不能按分录对账。
+// 例外是对决手续费：由托管转入庄家，记在获胜用户名下，两方均不是用户账户，不计入用户余额
+type LedgerEntry struct {
+	ID            uint   `gorm:"primarykey"`
+	ChatID        int64  `json:"chat_id" gorm:"type:bigint(20);not null;index:idx_chat_user"`
+	TgUserID      int64  `json:"tg_user_id" gorm:"type:bigint(20);not null;index:idx_chat_user"` // 用户ID
+	Type          int    `json:"type" gorm:"type:int(11);not null"`                              // 分录类型
+	DebitAccount  string `json:"debit_account" gorm:"type:varchar(64);not null"`                 // 借方账户(转出)
+	CreditAccount string `json:"credit_account" gorm:"type:varchar(64);not null"`                // 贷方账户(转入)
+	Amount        int    `json:"amount" gorm:"type:int(11);not null"`                            // 金额
+	Balance       int    `json:"balance" gorm:"type:int(11);not null"`                           // 变动后的用户余额
+	IssueNumber   string `json:"issue_number" gorm:"type:varchar(64);not null;default:''"`
+	BetRecordID   uint   `json:"bet_record_id" gorm:"not null;default:0"`
+	Remark        string `json:"remark" gorm:"type:varchar(255);not null;default:''"`
+	CreateTime    string `json:"create_time" gorm:"type:varchar(255);not null"`
+	ContraAccount string `json:"-" gorm:"-"` // 写入时指定的对方账户，为空时按分录类型确定，不存储
+}
+
+// LedgerUserAccount 获取用户账户名
+func LedgerUserAccount(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// LedgerTypeName 获取账本分录类型名称
+func LedgerTypeName(ledgerType int) string {
+	return ledgerTypeNames[ledgerType]
+}
+
+// SignedAmount 获取分录对用户余额的变动金额，入账为正，出账为负，系统账户之间的划转为 0
+func (e *LedgerEntry) SignedAmount() int {
+	switch LedgerUserAccount(e.TgUserID) {
+	case e.CreditAccount:
+		return e.Amount
+	case e.DebitAccount:
+		return -e.Amount
+	}
+	return 0
+}
+
+// ListLedgerByChatAndUser 获取用户最近的账本分录
+func ListLedgerByChatAndUser(db *gorm.DB, chatID int64, userID int64, limit int) ([]*LedgerEntry, error) {
+	var entries []*LedgerEntry
+	result := db.Where("chat_id = ? AND tg_user_id = ?", chatID, userID).Order("id desc").Limit(limit).Find(&entries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return entries, nil
+}
+
+// CountLedgerByChatAndUser 统计用户的账本分录数量
+func CountLedgerByChatAndUser(db *gorm.DB, chatID int64, userID int64) (int64, error) {
+	var count int64
+	result := db.Model(&LedgerEntry{}).Where("chat_id = ? AND tg_user_id = ?", chatID, userID).Count(&count)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return count, nil
+}
+
+// ListUsersWithoutLedger 获取还没有任何账本分录的用户
+func ListUsersWithoutLedger(db *gorm.DB) ([]*TgUser, error) {
+	var users []*TgUser
+	result := db.Where("NOT EXISTS (?)",
+		db.Model(&LedgerEntry{}).Select("1").Where("ledger_entries.chat_id = tg_users.chat_id AND ledger_entries.tg_user_id = tg_users.tg_user_id"),
+	).Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return users, nil
+}
+
+// LedgerBalance 按账本合计的用户余额
+type LedgerBalance struct {
+	ChatID   int64
+	TgUserID int64
+	Balance  int
+}
+
+// ledgerBalanceQuery 按对话和用户汇总账本，系统账户之间的划转不计入
+func ledgerBalanceQuery(db *gorm.DB) *gorm.DB {
+	return db.Model(&LedgerEntry{}).
+		Select("chat_id, tg_user_id, SUM(CASE WHEN credit_account LIKE 'user:%' THEN amount WHEN debit_account LIKE 'user:%' THEN -amount ELSE 0 END) AS balance").
+		Group("chat_id, tg_user_id")
+}
+
+// ListLedgerBalances 获取各用户按账本合计的余额，chatID 为 0 时获取所有对话
+func ListLedgerBalances(db *gorm.DB, chatID int64) ([]*LedgerBalance, error) {
+	var balances []*LedgerBalance
+	query := ledgerBalanceQuery(db)
+	if chatID != 0 {
+		query = query.Where("chat_id = ?", chatID)
+	}
+	result := query.Scan(&balances)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return balances, nil
+}
+
+// GetLedgerBalance 获取用户按账本合计的余额，没有分录时为 0
+func GetLedgerBalance(db *gorm.DB, chatID int64, userID int64) (int, error) {
+	var balances []*LedgerBalance
+	result := ledgerBalanceQuery(db).Where("chat_id = ? AND tg_user_id = ?", chatID, userID).Scan(&balances)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if len(balances) == 0 {
+		return 0, nil
+	}
+	return balances[0].Balance, nil
+}
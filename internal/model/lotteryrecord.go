@@ -3,17 +3,20 @@ package model
 import "gorm.io/gorm"
 
 type LotteryRecord struct {
-	ID           uint   `gorm:"primarykey"`
-	ChatID       int64  `json:"chat_id" gorm:"type:bigint(20);not null;index"`
-	IssueNumber  string `json:"issue_number" gorm:"type:varchar(64);not null"`
-	ValueA       int    `json:"value_a" gorm:"type:int(11);not null"`
-	ValueB       int    `json:"value_b" gorm:"type:int(11);not null"`
-	ValueC       int    `json:"value_c" gorm:"type:int(11);not null"`
-	Total        int    `json:"total" gorm:"type:int(11);not null"`
-	SingleDouble string `json:"single_double" gorm:"type:varchar(255);not null"`
-	BigSmall     string `json:"big_small" gorm:"type:varchar(255);not null"`
-	Triplet      int    `json:"triplet" gorm:"type:int(11);not null"`
-	Timestamp    string `json:"timestamp" gorm:"type:varchar(255);not null"`
+	ID            uint   `gorm:"primarykey"`
+	ChatID        int64  `json:"chat_id" gorm:"type:bigint(20);not null;index"`
+	IssueNumber   string `json:"issue_number" gorm:"type:varchar(64);not null"`
+	Game          string `json:"game" gorm:"type:varchar(32);not null;default:'dice'"` // 游戏
+	ValueA        int    `json:"value_a" gorm:"type:int(11);not null"`
+	ValueB        int    `json:"value_b" gorm:"type:int(11);not null"`
+	ValueC        int    `json:"value_c" gorm:"type:int(11);not null"`
+	Total         int    `json:"total" gorm:"type:int(11);not null"`
+	SingleDouble  string `json:"single_double" gorm:"type:varchar(255);not null"`
+	BigSmall      string `json:"big_small" gorm:"type:varchar(255);not null"`
+	Triplet       int    `json:"triplet" gorm:"type:int(11);not null"`
+	JackpotPayout int    `json:"jackpot_payout" gorm:"type:int(11);not null;default:0"` // 本期奖池派彩，结算时写入
+	Jackpot       int    `json:"jackpot" gorm:"type:int(11);not null;default:0"`        // 结算后的奖池余额
+	Timestamp     string `json:"timestamp" gorm:"type:varchar(255);not null"`
 }
 
 func GetAllRecordsByChatID(db *gorm.DB, chatID int64) ([]LotteryRecord, error) {
@@ -26,3 +29,29 @@ func GetAllRecordsByChatID(db *gorm.DB, chatID int64) ([]LotteryRecord, error) {
 
 	return records, nil
 }
+
+// ListByChatIDAndIssueNumbers 根据对话ID和期号列表获取开奖记录，以期号为键返回
+func ListByChatIDAndIssueNumbers(db *gorm.DB, chatID int64, issueNumbers []string) (map[string]*LotteryRecord, error) {
+	var records []*LotteryRecord
+
+	result := db.Where("chat_id = ? AND issue_number IN ?", chatID, issueNumbers).Find(&records)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	recordMap := make(map[string]*LotteryRecord, len(records))
+	for _, record := range records {
+		recordMap[record.IssueNumber] = record
+	}
+	return recordMap, nil
+}
+
+// GetByChatIDAndIssueNumber 根据对话ID和期号获取开奖记录
+func GetByChatIDAndIssueNumber(db *gorm.DB, chatID int64, issueNumber string) (*LotteryRecord, error) {
+	var record *LotteryRecord
+	result := db.Where("chat_id = ? AND issue_number = ?", chatID, issueNumber).First(&record)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return record, nil
+}
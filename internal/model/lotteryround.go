@@ -0,0 +1,200 @@
+package model
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// 期号状态
+const (
+	RoundStatusOpen     = 0 // 开盘
+	RoundStatusClosed   = 1 // 封盘
+	RoundStatusRolling  = 2 // 开奖中
+	RoundStatusDrawn    = 3 // 已开奖
+	RoundStatusSettling = 4 // 结算中
+	RoundStatusSettled  = 5 // 已结算
+	RoundStatusVoided   = 6 // 已作废
+)
+
+// ErrInvalidRoundTransition 期号状态不允许该流转
+var ErrInvalidRoundTransition = errors.New("期号状态流转无效")
+
+// roundTransitions 各状态允许流转到的目标状态
+var roundTransitions = map[int][]int{
+	RoundStatusOpen:     {RoundStatusClosed, RoundStatusRolling, RoundStatusVoided},
+	RoundStatusClosed:   {RoundStatusRolling, RoundStatusVoided},
+	RoundStatusRolling:  {RoundStatusDrawn, RoundStatusVoided},
+	RoundStatusDrawn:    {RoundStatusSettling},
+	RoundStatusSettling: {RoundStatusSettled},
+	RoundStatusSettled:  {},
+	RoundStatusVoided:   {},
+}
+
+// roundStatusNames 期号状态名称
+var roundStatusNames = map[int]string{
+	RoundStatusOpen:     "开盘",
+	RoundStatusClosed:   "封盘",
+	RoundStatusRolling:  "开奖中",
+	RoundStatusDrawn:    "已开奖",
+	RoundStatusSettling: "结算中",
+	RoundStatusSettled:  "已结算",
+	RoundStatusVoided:   "已作废",
+}
+
+// LotteryRound 期号，记录每期的生命周期状态
+type LotteryRound struct {
+	ID          uint   `gorm:"primarykey"`
+	ChatID      int64  `json:"chat_id" gorm:"type:bigint(20);not null;uniqueIndex:idx_chat_issue"`
+	IssueNumber string `json:"issue_number" gorm:"type:varchar(64);not null;uniqueIndex:idx_chat_issue"`
+	Status      int    `json:"status" gorm:"type:int(11);not null;index"`                // 期号状态
+	DrawTime    string `json:"draw_time" gorm:"type:varchar(255);not null"`              // 计划开奖时间
+	DrawMode    string `json:"draw_mode" gorm:"type:varchar(32);not null;default:''"`    // 开奖方式，创建时按对话配置确定
+	Game        string `json:"game" gorm:"type:varchar(32);not null;default:''"`         // 游戏，创建时按对话配置确定
+	BankerID    uint   `json:"banker_id" gorm:"not null;default:0;index"`                // 坐庄的玩家庄家ID，0 表示由机器人坐庄
+	ServerSeed  string `json:"-" gorm:"type:varchar(64);not null;default:''"`            // 服务器种子，开奖后公布
+	SeedHash    string `json:"seed_hash" gorm:"type:varchar(64);not null;default:''"`    // 服务器种子的 SHA256 承诺
+	ClientSeed  string `json:"client_seed" gorm:"type:varchar(128);not null;default:''"` // 客户端种子，停止下注时由客户端种子原文生成
+	ClientText  string `json:"client_text" gorm:"type:text;not null"`                    // 客户端种子原文，停止下注时公布的本期下注
+	UpdateTime  string `json:"update_time" gorm:"type:varchar(255);not null"`
+	CreateTime  string `json:"create_time" gorm:"type:varchar(255);not null"`
+}
+
+// RoundStatusName 获取期号状态名称
+func RoundStatusName(status int) string {
+	return roundStatusNames[status]
+}
+
+// CanTransitionRound 判断期号状态是否允许流转到目标状态
+func CanTransitionRound(from int, to int) bool {
+	for _, status := range roundTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRound 根据对话ID和期号获取期号
+func GetRound(db *gorm.DB, chatID int64, issueNumber string) (*LotteryRound, error) {
+	var round *LotteryRound
+	result := db.Where("chat_id = ? AND issue_number = ?", chatID, issueNumber).First(&round)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return round, nil
+}
+
+// GetLatestRoundByStatus 获取对话中处于指定状态的最新期号
+func GetLatestRoundByStatus(db *gorm.DB, chatID int64, statuses []int) (*LotteryRound, error) {
+	var round *LotteryRound
+	result := db.Where("chat_id = ? AND status IN ?", chatID, statuses).Order("issue_number desc").First(&round)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return round, nil
+}
+
+// ListRoundsByStatus 获取所有处于指定状态的期号
+func ListRoundsByStatus(db *gorm.DB, statuses []int) ([]*LotteryRound, error) {
+	var rounds []*LotteryRound
+	result := db.Where("status IN ?", statuses).Order("issue_number").Find(&rounds)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return rounds, nil
+}
+
+// SetRoundClientSeed 保存期号的客户端种子和原文，只在尚未保存时写入，返回是否写入
+func SetRoundClientSeed(db *gorm.DB, chatID int64, issueNumber string, clientSeed string, clientText string) (bool, error) {
+	result := db.Model(&LotteryRound{}).
+		Where("chat_id = ? AND issue_number = ? AND client_seed = ''", chatID, issueNumber).
+		Updates(map[string]interface{}{
+			"client_seed": clientSeed,
+			"client_text": clientText,
+			"update_time": time.Now().Format("2006-01-02 15:04:05"),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// RoundSeed 期号的公平开奖种子
+type RoundSeed struct {
+	ServerSeed string
+	SeedHash   string
+	ClientSeed string
+}
+
+// OpenRound 创建开盘状态的期号，期号已存在且仍在开盘时更新计划开奖时间，已封盘的期号保持已公布的开奖时间，
+// 开奖方式、游戏和种子只在创建时写入
+func OpenRound(db *gorm.DB, chatID int64, issueNumber string, drawTime time.Time, drawMode string, game string, seed *RoundSeed) (*LotteryRound, error) {
+	currentTime := time.Now().Format("2006-01-02 15:04:05")
+	round, err := GetRound(db, chatID, issueNumber)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		round = &LotteryRound{
+			ChatID:      chatID,
+			IssueNumber: issueNumber,
+			Status:      RoundStatusOpen,
+			DrawTime:    drawTime.Format("2006-01-02 15:04:05"),
+			DrawMode:    drawMode,
+			Game:        game,
+			UpdateTime:  currentTime,
+			CreateTime:  currentTime,
+		}
+		if seed != nil {
+			round.ServerSeed = seed.ServerSeed
+			round.SeedHash = seed.SeedHash
+			round.ClientSeed = seed.ClientSeed
+		}
+		result := db.Create(round)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		return round, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if round.Status != RoundStatusOpen {
+		return round, nil
+	}
+
+	round.DrawTime = drawTime.Format("2006-01-02 15:04:05")
+	round.UpdateTime = currentTime
+	result := db.Model(round).Updates(map[string]interface{}{
+		"draw_time":   round.DrawTime,
+		"update_time": round.UpdateTime,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return round, nil
+}
+
+// TransitionRound 将期号流转到目标状态，仅当当前状态允许该流转时更新，
+// 条件更新保证并发或多实例下同一流转只会成功一次
+func TransitionRound(db *gorm.DB, chatID int64, issueNumber string, to int) error {
+	var froms []int
+	for from, tos := range roundTransitions {
+		for _, status := range tos {
+			if status == to {
+				froms = append(froms, from)
+			}
+		}
+	}
+
+	result := db.Model(&LotteryRound{}).
+		Where("chat_id = ? AND issue_number = ? AND status IN ?", chatID, issueNumber, froms).
+		Updates(map[string]interface{}{
+			"status":      to,
+			"update_time": time.Now().Format("2006-01-02 15:04:05"),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidRoundTransition
+	}
+	return nil
+}
@@ -1,5 +1,7 @@
 package model
 
+import "gorm.io/gorm"
+
 type TgUser struct {
 	ID         int    `gorm:"primaryKey"`
 	TgUserID   int64  `json:"tg_user_id" gorm:"type:bigint(20);not null"` // Telegram 用户ID
@@ -8,3 +10,17 @@ type TgUser struct {
 	Balance    int    `json:"balance" gorm:"type:int(11);not null"`
 	SignInTime string `json:"sign_in_time" gorm:"type:varchar(500)"` // 签到时间
 }
+
+// ListUsersByChatID 获取对话中的用户，chatID 为 0 时获取所有对话的用户
+func ListUsersByChatID(db *gorm.DB, chatID int64) ([]*TgUser, error) {
+	var users []*TgUser
+	query := db.Order("chat_id, tg_user_id")
+	if chatID != 0 {
+		query = query.Where("chat_id = ?", chatID)
+	}
+	result := query.Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return users, nil
+}
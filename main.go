@@ -1,9 +1,15 @@
 package main
 
 import (
+	"os"
 	"tg-dice-bot/internal/bot"
 )
 
 func main() {
+	// 对账子命令: tg-dice-bot reconcile [-chat <聊天ID>] [-repair]
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		bot.RunReconcile(os.Args[2:])
+		return
+	}
 	bot.StartBot()
 }